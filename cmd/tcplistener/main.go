@@ -1,25 +1,62 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"httpfromtcp/internal/accesslog"
+	"httpfromtcp/internal/auth"
+	"httpfromtcp/internal/compress"
+	"httpfromtcp/internal/conditional"
+	"httpfromtcp/internal/cors"
+	"httpfromtcp/internal/fileserver"
 	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/proxy"
+	"httpfromtcp/internal/ratelimit"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/restart"
 	"httpfromtcp/internal/server"
-	"log"
+	"httpfromtcp/internal/websocket"
+	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
-const port = 3000
-
 const html400 = `<html><head><title>400 Bad Request</title></head><body><h1>Bad Request</h1><p>The request could not be processed.</p></body></html>`
 const html500 = `<html><head><title>500 Internal Server Error</title></head><body><h1>Internal Server Error</h1><p>An unexpected error occurred on the server.</p></body></html>`
-const html200 = `<html><head><title>200 OK</title></head><body><h1>Success</h1><p>Request processed successfully.</p></body></html>`
+
+var (
+	addr       = flag.String("addr", ":3000", "address to listen on, e.g. 127.0.0.1:3000, [::1]:3000 or unix:/run/httpserver.sock; ignored when socket-activated by systemd")
+	socketMode = flag.String("socket-mode", "", "permissions of a unix: socket, in octal, e.g. 660")
+	staticDir  = flag.String("dir", "./public", "directory to serve static files from")
+	proxyMode  = flag.Bool("proxy", false, "run as a forward proxy (CONNECT tunnels and absolute-form requests) to public hosts on ports 80 and 443; listens on 127.0.0.1:3000 unless -addr is given")
+	accessLog  = flag.String("access-log", "", "write an access log to stdout: common, combined or json")
+	logLevel   = flag.String("log-level", "info", "minimum level of server logs on stderr: debug, info, warn or error")
+	logJSON    = flag.Bool("log-json", false, "write server logs to stderr as JSON instead of text")
+	drainTime  = flag.Duration("drain-timeout", 30*time.Second, "how long the old process serves open connections after a restart (SIGHUP or SIGUSR2)")
+	maxConns   = flag.Int("max-conns", 0, "maximum number of connections served at once; 0 means no limit")
+	corsOrigin = flag.String("cors", "", "comma-separated origins allowed to make cross-origin requests, e.g. https://app.example.com,https://*.example.com or *")
+	htpasswd   = flag.String("htpasswd", "", "require HTTP Basic authentication against this htpasswd file (bcrypt entries, htpasswd -B)")
+	rateLimit  = flag.Int("rate-limit", 0, "requests per minute allowed per client IP; 0 means no limit")
+	etags      = flag.Bool("etag", false, "tag responses with an ETag and answer conditional GET and HEAD requests with 304 or 412")
+	rejectFull = flag.Duration("reject-over-limit", 0, "answer connections over -max-conns with 503 and this Retry-After instead of queueing them")
+)
+
+var (
+	serveFile    server.Handler
+	serveMetrics server.Handler
+)
 
 func myHandler(w *response.Writer, req *request.Request) {
-	log.Printf("Handling request for target: %s", req.RequestLine.RequestTarget)
+	logger := request.LoggerFromContext(req.Context())
+	logger.Debug("handling request", "target", req.RequestLine.RequestTarget)
 
 	var statusCode response.StatusCode
 	var bodyHTML string
@@ -31,14 +68,20 @@ func myHandler(w *response.Writer, req *request.Request) {
 	case "/api/internal":
 		statusCode = response.StatusInternalServerError
 		bodyHTML = html500
+	case "/ws/echo":
+		echoWebSocket(w, req)
+		return
+	case "/metrics":
+		serveMetrics(w, req)
+		return
 	default:
-		statusCode = response.StatusOK
-		bodyHTML = html200
+		serveFile(w, req)
+		return
 	}
 
 	err := w.WriteStatusLine(statusCode)
 	if err != nil {
-		log.Printf("Error writing status line: %v", err)
+		logger.Debug("cannot write status line", "category", "write", "error", err)
 		return
 	}
 
@@ -49,29 +92,183 @@ func myHandler(w *response.Writer, req *request.Request) {
 
 	err = w.WriteHeaders(h)
 	if err != nil {
-		log.Printf("Error writing headers: %v", err)
+		logger.Debug("cannot write headers", "category", "write", "error", err)
 		return
 	}
 
 	_, err = w.WriteBody([]byte(bodyHTML))
 	if err != nil {
-		log.Printf("Error writing body: %v", err)
+		logger.Debug("cannot write body", "category", "write", "error", err)
+	}
+}
+
+// echoWebSocket upgrades the connection and sends every message back.
+func echoWebSocket(w *response.Writer, req *request.Request) {
+	logger := request.LoggerFromContext(req.Context())
+	ws, err := websocket.Upgrade(w, req)
+	if err != nil {
+		logger.Info("websocket handshake failed", "category", "websocket", "error", err)
 		return
 	}
+	defer ws.Close()
+
+	for {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			logger.Debug("websocket closed", "reason", err)
+			return
+		}
+		if err := ws.WriteMessage(messageType, data); err != nil {
+			logger.Debug("cannot write websocket message", "category", "write", "error", err)
+			return
+		}
+	}
+}
+
+// listen takes over the socket of the process this one replaces, or uses
+// the one systemd passed in if the process was socket-activated, and
+// otherwise listens on addr.
+func listen(addr, mode string) (net.Listener, error) {
+	listeners, err := restart.Inherit()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) == 0 {
+		listeners, err = server.SystemdListeners()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		for _, extra := range listeners[1:] {
+			extra.Close()
+		}
+		return listeners[0], nil
+	}
+
+	path, ok := strings.CutPrefix(addr, server.UnixPrefix)
+	if !ok {
+		return server.Listen(addr)
+	}
+	var perm uint64
+	if mode != "" {
+		if perm, err = strconv.ParseUint(mode, 8, 32); err != nil || perm > 0o777 {
+			return nil, fmt.Errorf("invalid -socket-mode %q", mode)
+		}
+	}
+	return server.ListenUnix(path, os.FileMode(perm))
+}
 
-	log.Printf("Sent response %d for %s", statusCode, req.RequestLine.RequestTarget)
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 func main() {
-	srv, err := server.Serve(port, myHandler)
+	flag.Parse()
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -log-level: %v\n", err)
+		os.Exit(2)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var logHandler slog.Handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	if *logJSON {
+		logHandler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+	}
+	logger := slog.New(logHandler)
+
+	serveFile = fileserver.Handler(*staticDir)
+	registry := metrics.NewRegistry()
+	serveMetrics = metrics.Handler(registry)
+
+	handler := compress.Middleware(compress.Config{})(myHandler)
+	if *etags {
+		handler = conditional.Middleware(conditional.Config{})(handler)
+	}
+	if *proxyMode {
+		handler = proxy.Handler(proxy.Config{})
+	}
+	if *htpasswd != "" {
+		users, err := auth.LoadHtpasswd(*htpasswd)
+		if err != nil {
+			logger.Error("cannot load -htpasswd", "error", err)
+			os.Exit(1)
+		}
+		handler = auth.Basic(auth.BasicConfig{Users: users, Proxy: *proxyMode})(handler)
+	}
+	if *corsOrigin != "" {
+		handler = cors.Middleware(cors.Config{AllowedOrigins: strings.Split(*corsOrigin, ",")})(handler)
+	}
+	if *rateLimit > 0 {
+		handler = ratelimit.Middleware(ratelimit.Config{Limit: *rateLimit})(handler)
+	}
+
+	opts := []server.Option{
+		server.WithLogger(logger),
+		server.WithMetrics(registry),
+		server.WithReadTimeout(30 * time.Second),
+		server.WithMaxConnections(*maxConns),
+	}
+	if *rejectFull > 0 {
+		opts = append(opts, server.WithRejectOverLimit(*rejectFull))
+	}
+	if *accessLog != "" {
+		format, err := accesslog.ParseFormat(*accessLog)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -access-log: %v\n", err)
+			os.Exit(2)
+		}
+		opts = append(opts, server.WithAccessLog(accesslog.New(os.Stdout, format)))
+	}
+
+	listenAddr := *addr
+	if *proxyMode && !flagSet("addr") {
+		// A proxy reachable on every interface would relay for anyone.
+		listenAddr = "127.0.0.1:3000"
+	}
+	listener, err := listen(listenAddr, *socketMode)
 	if err != nil {
-		log.Fatalf("Error starting server: %v", err)
+		logger.Error("cannot listen", "error", err)
+		os.Exit(1)
+	}
+	srv, err := server.ServeListener(listener, handler, opts...)
+	if err != nil {
+		logger.Error("cannot start server", "error", err)
+		os.Exit(1)
+	}
+	if err := restart.Ready(); err != nil {
+		logger.Warn("cannot notify the previous process", "error", err)
 	}
-	defer srv.Close()
-	log.Printf("Server started on port %d", port)
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-	log.Println("Received shutdown signal, stopping server...")
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP || sig == syscall.SIGUSR2 {
+			logger.Info("restarting", "signal", sig.String())
+			child, err := restart.Start(0, listener)
+			if err != nil {
+				logger.Error("restart failed, still serving", "error", err)
+				continue
+			}
+			logger.Info("replacement is serving, draining connections", "pid", child.Pid)
+			ctx, cancel := context.WithTimeout(context.Background(), *drainTime)
+			if err := srv.Shutdown(ctx); err != nil {
+				logger.Warn("connections still open after drain timeout", "error", err)
+			}
+			cancel()
+			return
+		}
+
+		logger.Info("received shutdown signal, stopping server")
+		srv.Close()
+		return
+	}
 }
@@ -0,0 +1,167 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format selects how a Logger renders entries.
+type Format int
+
+const (
+	// Common is the Apache Common Log Format:
+	//   host ident authuser [date] "request" status bytes
+	Common Format = iota
+	// Combined is Common followed by the quoted Referer and User-Agent.
+	Combined
+	// JSON writes one JSON object per line through a log/slog JSON handler.
+	JSON
+)
+
+// ParseFormat maps "common", "combined" or "json" to a Format.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "common":
+		return Common, nil
+	case "combined":
+		return Combined, nil
+	case "json":
+		return JSON, nil
+	}
+	return 0, fmt.Errorf("unknown access log format %q", name)
+}
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// Entry describes one completed request.
+type Entry struct {
+	Time      time.Time
+	RemoteIP  string
+	User      string
+	Method    string
+	Target    string
+	Proto     string
+	Status    int
+	Bytes     int64
+	Duration  time.Duration
+	Referer   string
+	UserAgent string
+	RequestID string
+}
+
+// Logger writes access log entries. It is safe for concurrent use.
+type Logger struct {
+	format Format
+	slog   *slog.Logger
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New returns a Logger writing entries to w in the given format.
+func New(w io.Writer, format Format) *Logger {
+	l := &Logger{format: format, w: w}
+	if format == JSON {
+		l.slog = slog.New(slog.NewJSONHandler(w, nil))
+	}
+	return l
+}
+
+// NewSlog returns a Logger that hands entries to logger as structured
+// records, so they can be routed with the rest of an application's logs.
+func NewSlog(logger *slog.Logger) *Logger {
+	return &Logger{format: JSON, slog: logger}
+}
+
+// Log records e.
+func (l *Logger) Log(e Entry) {
+	if l.slog != nil {
+		l.slog.LogAttrs(context.Background(), slog.LevelInfo, "request", attrs(e)...)
+		return
+	}
+
+	line := commonLine(e)
+	if l.format == Combined {
+		line += " " + quote(e.Referer) + " " + quote(e.UserAgent)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line+"\n")
+}
+
+func attrs(e Entry) []slog.Attr {
+	a := []slog.Attr{
+		slog.String("remote_ip", e.RemoteIP),
+		slog.String("method", e.Method),
+		slog.String("target", e.Target),
+		slog.String("proto", e.Proto),
+		slog.Int("status", e.Status),
+		slog.Int64("bytes", e.Bytes),
+		slog.Duration("duration", e.Duration),
+	}
+	if e.User != "" {
+		a = append(a, slog.String("user", e.User))
+	}
+	if e.Referer != "" {
+		a = append(a, slog.String("referer", e.Referer))
+	}
+	if e.UserAgent != "" {
+		a = append(a, slog.String("user_agent", e.UserAgent))
+	}
+	if e.RequestID != "" {
+		a = append(a, slog.String("request_id", e.RequestID))
+	}
+	return a
+}
+
+func commonLine(e Entry) string {
+	request := "-"
+	if e.Method != "" {
+		request = e.Method + " " + e.Target + " " + e.Proto
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] %s %d %s",
+		orDash(e.RemoteIP), orDash(escape(e.User)), e.Time.Format(clfTimeFormat),
+		quote(request), e.Status, bytes)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// quote renders s as a quoted log field, using "-" for an empty value.
+func quote(s string) string {
+	return `"` + escape(orDash(s)) + `"`
+}
+
+// escape keeps client-supplied values from forging log lines: quotes and
+// backslashes are backslash-escaped and control characters are written as
+// \xHH, as Apache does.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
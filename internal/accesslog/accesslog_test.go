@@ -0,0 +1,78 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var entry = Entry{
+	Time:      time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+	RemoteIP:  "127.0.0.1",
+	Method:    "GET",
+	Target:    "/apache_pb.gif",
+	Proto:     "HTTP/1.0",
+	Status:    200,
+	Bytes:     2326,
+	Duration:  1500 * time.Microsecond,
+	Referer:   "http://www.example.com/start.html",
+	UserAgent: "Mozilla/4.08",
+}
+
+func TestCommon(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, Common).Log(entry)
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`+"\n", buf.String())
+}
+
+func TestCombined(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, Combined).Log(entry)
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`+"\n", buf.String())
+}
+
+func TestCombinedEmptyAndHostileFields(t *testing.T) {
+	var buf bytes.Buffer
+	e := entry
+	e.Bytes = 0
+	e.Referer = ""
+	e.UserAgent = "evil\" \n127.0.0.1 - - fake"
+	New(&buf, Combined).Log(e)
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 - "-" "evil\" \x0a127.0.0.1 - - fake"`+"\n", buf.String())
+}
+
+func TestUnparsedRequest(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, Common).Log(Entry{Time: entry.Time, RemoteIP: "::1", Status: 400, Bytes: 10})
+	assert.Equal(t, `::1 - - [10/Oct/2000:13:55:36 -0700] "-" 400 10`+"\n", buf.String())
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, JSON).Log(entry)
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, "request", got["msg"])
+	assert.Equal(t, "127.0.0.1", got["remote_ip"])
+	assert.Equal(t, "GET", got["method"])
+	assert.Equal(t, "/apache_pb.gif", got["target"])
+	assert.Equal(t, "HTTP/1.0", got["proto"])
+	assert.EqualValues(t, 200, got["status"])
+	assert.EqualValues(t, 2326, got["bytes"])
+	assert.EqualValues(t, 1500000, got["duration"])
+	assert.Equal(t, "Mozilla/4.08", got["user_agent"])
+	assert.Equal(t, "http://www.example.com/start.html", got["referer"])
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("Combined")
+	require.NoError(t, err)
+	assert.Equal(t, Combined, f)
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+)
+
+// DefaultRealm names the protection space in challenges when no realm is
+// configured.
+const DefaultRealm = "restricted"
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	claimsKey
+)
+
+// UserFromContext returns the authenticated user: the Basic user name, or
+// the "sub" claim of a bearer token. It returns "" if the request was not
+// authenticated by this package.
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey).(string)
+	return user
+}
+
+// ClaimsFromContext returns the claims of the bearer token the request was
+// authenticated with, or nil.
+func ClaimsFromContext(ctx context.Context) Claims {
+	claims, _ := ctx.Value(claimsKey).(Claims)
+	return claims
+}
+
+// withUser returns req carrying user and, for bearer tokens, claims.
+func withUser(req *request.Request, user string, claims Claims) *request.Request {
+	ctx := context.WithValue(req.Context(), userKey, user)
+	if claims != nil {
+		ctx = context.WithValue(ctx, claimsKey, claims)
+	}
+	return req.WithContext(ctx)
+}
+
+// credentials splits an Authorization header into its scheme, compared
+// case-insensitively with want, and the credentials that follow.
+func credentials(authorization, want string) (string, bool) {
+	scheme, rest, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, want) {
+		return "", false
+	}
+	rest = strings.TrimLeft(rest, " ")
+	return rest, rest != ""
+}
+
+// challenge answers 401 with a WWW-Authenticate challenge for scheme built
+// from params, given as name/value pairs, or 407 with a Proxy-Authenticate
+// challenge if proxy is set.
+func challenge(w *response.Writer, proxy bool, scheme string, params ...string) {
+	var b strings.Builder
+	b.WriteString(scheme)
+	for i := 0; i+1 < len(params); i += 2 {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(params[i] + "=" + quote(params[i+1]))
+	}
+	h := headers.NewHeaders()
+	if proxy {
+		h.Set("Proxy-Authenticate", b.String())
+		w.WriteError(response.StatusProxyAuthRequired, h)
+		return
+	}
+	h.Set("WWW-Authenticate", b.String())
+	w.WriteError(response.StatusUnauthorized, h)
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func quote(s string) string {
+	return `"` + quoteEscaper.Replace(s) + `"`
+}
+
+func realmOrDefault(realm string) string {
+	if realm == "" {
+		return DefaultRealm
+	}
+	return realm
+}
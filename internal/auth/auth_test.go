@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// run sends rawRequest through mw and returns the raw response along with
+// the user the handler saw.
+func run(t *testing.T, mw func(next server.Handler) server.Handler, rawRequest string) (string, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	var user string
+	w := response.NewWriter(&buf)
+	mw(func(w *response.Writer, req *request.Request) {
+		user = UserFromContext(req.Context())
+		w.WriteError(response.StatusOK, nil)
+	})(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String(), user
+}
+
+func basicRequest(user, password string) string {
+	return "GET / HTTP/1.1\r\nAuthorization: Basic " +
+		base64.StdEncoding.EncodeToString([]byte(user+":"+password)) + "\r\n\r\n"
+}
+
+func TestBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	htpasswd, err := ParseHtpasswd(strings.NewReader("# users\n\nalice:" + string(hash) + "\n"))
+	require.NoError(t, err)
+
+	for name, users := range map[string]Verifier{
+		"credentials": Credentials{"alice": "s3cret"},
+		"htpasswd":    htpasswd,
+	} {
+		t.Run(name, func(t *testing.T) {
+			mw := Basic(BasicConfig{Realm: `the "admin" area`, Users: users})
+
+			out, user := run(t, mw, basicRequest("alice", "s3cret"))
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+			assert.Equal(t, "alice", user)
+
+			for _, raw := range []string{
+				basicRequest("alice", "wrong"),
+				basicRequest("bob", "s3cret"),
+				"GET / HTTP/1.1\r\n\r\n",
+				"GET / HTTP/1.1\r\nAuthorization: Basic !!!\r\n\r\n",
+				"GET / HTTP/1.1\r\nAuthorization: Bearer abc\r\n\r\n",
+			} {
+				out, user := run(t, mw, raw)
+				assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"), raw)
+				assert.Contains(t, out, `www-authenticate: Basic realm="the \"admin\" area", charset="UTF-8"`+"\r\n")
+				assert.Empty(t, user)
+			}
+		})
+	}
+}
+
+func TestBasicProxy(t *testing.T) {
+	mw := Basic(BasicConfig{Users: Credentials{"alice": "s3cret"}, Proxy: true})
+	credentials := base64.StdEncoding.EncodeToString([]byte("alice:s3cret"))
+
+	out, user := run(t, mw, "GET http://example.com/ HTTP/1.1\r\nProxy-Authorization: Basic "+credentials+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Equal(t, "alice", user)
+
+	// Credentials meant for the origin do not authenticate to the proxy.
+	out, user = run(t, mw, basicRequest("alice", "s3cret"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 407 Proxy Authentication Required\r\n"), out)
+	assert.Contains(t, out, `proxy-authenticate: Basic realm="restricted", charset="UTF-8"`+"\r\n")
+	assert.NotContains(t, out, "www-authenticate")
+	assert.Empty(t, user)
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	_, err := ParseHtpasswd(strings.NewReader("alice:$apr1$abc$def\n"))
+	assert.ErrorContains(t, err, "line 1")
+	_, err = ParseHtpasswd(strings.NewReader("\nalice\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestBearer(t *testing.T) {
+	mw := Bearer(BearerConfig{Tokens: ValidatorFunc(func(token string) (Claims, error) {
+		switch token {
+		case "good":
+			return Claims{"sub": "alice"}, nil
+		case "expired":
+			return nil, ErrTokenExpired
+		}
+		return nil, errors.New("database unavailable")
+	})})
+
+	out, user := run(t, mw, "GET / HTTP/1.1\r\nAuthorization: bearer good\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Equal(t, "alice", user)
+
+	out, _ = run(t, mw, "GET / HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, `www-authenticate: Bearer realm="restricted"`+"\r\n")
+
+	out, _ = run(t, mw, "GET / HTTP/1.1\r\nAuthorization: Bearer expired\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"), out)
+	assert.Contains(t, out, `www-authenticate: Bearer realm="restricted", error="invalid_token", error_description="invalid token: token expired"`+"\r\n")
+
+	// Other errors are not described to the client.
+	out, _ = run(t, mw, "GET / HTTP/1.1\r\nAuthorization: Bearer other\r\n\r\n")
+	assert.Contains(t, out, `www-authenticate: Bearer realm="restricted", error="invalid_token"`+"\r\n")
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t *testing.T, key []byte, alg string, claims map[string]any) string {
+	signed := encodeSegment(t, map[string]string{"alg": alg, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	signed := encodeSegment(t, map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTValidator(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	v := &JWTValidator{HMACKey: key, Audience: "api", Issuer: "https://issuer.example", Leeway: time.Minute}
+	v.now = func() time.Time { return now }
+
+	valid := map[string]any{
+		"sub": "alice",
+		"iss": "https://issuer.example",
+		"aud": []string{"web", "api"},
+		"exp": now.Unix() + 60,
+		"nbf": now.Unix() - 60,
+	}
+	claims, err := v.Validate(signHS256(t, key, "HS256", valid))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims.Subject())
+	assert.Equal(t, json.Number("1700000060"), claims["exp"])
+
+	with := func(name string, value any) map[string]any {
+		c := make(map[string]any)
+		for k, v := range valid {
+			c[k] = v
+		}
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"within leeway", signHS256(t, key, "HS256", with("exp", now.Unix()-30)), nil},
+		{"expired", signHS256(t, key, "HS256", with("exp", now.Unix()-60)), ErrTokenExpired},
+		{"not yet valid", signHS256(t, key, "HS256", with("nbf", now.Unix()+61)), ErrTokenNotYetValid},
+		{"single audience", signHS256(t, key, "HS256", with("aud", "api")), nil},
+		{"other audience", signHS256(t, key, "HS256", with("aud", "web")), ErrTokenAudience},
+		{"no audience", signHS256(t, key, "HS256", with("aud", nil)), ErrTokenAudience},
+		{"issuer", signHS256(t, key, "HS256", with("iss", "https://evil.example")), ErrTokenIssuer},
+		{"string exp", signHS256(t, key, "HS256", with("exp", "tomorrow")), ErrTokenMalformed},
+		{"wrong key", signHS256(t, []byte("another key"), "HS256", valid), ErrTokenSignature},
+		{"alg none", signHS256(t, key, "none", valid), ErrTokenAlgorithm},
+		{"two segments", "abc.def", ErrTokenMalformed},
+		{"bad base64", "abc.def.g!h", ErrTokenMalformed},
+	}
+	for _, tt := range tests {
+		_, err := v.Validate(tt.token)
+		if tt.want == nil {
+			assert.NoError(t, err, tt.name)
+			continue
+		}
+		assert.ErrorIs(t, err, tt.want, tt.name)
+		assert.ErrorIs(t, err, ErrInvalidToken, tt.name)
+	}
+}
+
+func TestJWTValidatorRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	v := &JWTValidator{RSAKey: &key.PublicKey}
+
+	claims, err := v.Validate(signRS256(t, key, map[string]any{"sub": "bob"}))
+	require.NoError(t, err)
+	assert.Equal(t, "bob", claims.Subject())
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = v.Validate(signRS256(t, other, map[string]any{"sub": "bob"}))
+	assert.ErrorIs(t, err, ErrTokenSignature)
+
+	// An HMAC token keyed with something public must not get through.
+	_, err = v.Validate(signHS256(t, key.PublicKey.N.Bytes(), "HS256", map[string]any{"sub": "bob"}))
+	assert.ErrorIs(t, err, ErrTokenAlgorithm)
+}
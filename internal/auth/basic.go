@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Verifier checks a user name and password.
+type Verifier interface {
+	Verify(user, password string) bool
+}
+
+// Credentials maps user names to plain-text passwords. Passwords are
+// compared in constant time, and unknown users take as long to reject as
+// known ones.
+type Credentials map[string]string
+
+func (c Credentials) Verify(user, password string) bool {
+	want, ok := c[user]
+	// Comparing digests keeps the time independent of the lengths too.
+	got := sha256.Sum256([]byte(password))
+	expected := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(got[:], expected[:]) == 1 && ok
+}
+
+// Htpasswd holds bcrypt password hashes by user name, as found in an Apache
+// htpasswd file created with "htpasswd -B".
+type Htpasswd map[string][]byte
+
+// dummyHash is checked against for unknown users, so that they take as long
+// to reject as a wrong password.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	return hash
+})
+
+func (h Htpasswd) Verify(user, password string) bool {
+	hash, ok := h[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// LoadHtpasswd reads an htpasswd file.
+func LoadHtpasswd(path string) (Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, err := ParseHtpasswd(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return h, nil
+}
+
+// ParseHtpasswd reads "user:hash" lines. Blank lines and lines starting
+// with # are skipped. Only bcrypt hashes are accepted; the MD5, SHA-1 and
+// crypt formats htpasswd also produces are too weak to be worth supporting.
+func ParseHtpasswd(r io.Reader) (Htpasswd, error) {
+	h := make(Htpasswd)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("line %d: user %q: not a bcrypt hash", n, user)
+		}
+		h[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+type BasicConfig struct {
+	// Realm is sent in the challenge; DefaultRealm if empty.
+	Realm string
+	// Users checks the credentials, e.g. Credentials or Htpasswd.
+	Users Verifier
+	// Proxy authenticates clients of a forward proxy instead: credentials
+	// are read from Proxy-Authorization and a failure is answered with 407
+	// and a Proxy-Authenticate challenge (RFC 9110 11.7).
+	Proxy bool
+}
+
+// Basic returns middleware that requires HTTP Basic authentication (RFC
+// 7617). Requests without valid credentials get 401 with a Basic
+// challenge, or 407 in Proxy mode; the others reach next with the user name
+// available from UserFromContext.
+func Basic(cfg BasicConfig) func(next server.Handler) server.Handler {
+	realm := realmOrDefault(cfg.Realm)
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			field := "Authorization"
+			if cfg.Proxy {
+				field = "Proxy-Authorization"
+			}
+			user, password, ok := parseBasic(req.Headers.Get(field))
+			if !ok || !cfg.Users.Verify(user, password) {
+				if ok {
+					request.LoggerFromContext(req.Context()).Info("authentication failed", "category", "auth", "user", user)
+				}
+				challenge(w, cfg.Proxy, "Basic", "realm", realm, "charset", "UTF-8")
+				return
+			}
+			next(w, withUser(req, user, nil))
+		}
+	}
+}
+
+func parseBasic(authorization string) (user, password string, ok bool) {
+	encoded, ok := credentials(authorization, "Basic")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Claims are the assertions a bearer token makes about its holder, such as
+// the claims of a JWT.
+type Claims map[string]any
+
+// Subject returns the "sub" claim, or "".
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// ErrInvalidToken is wrapped by the errors a Validator returns for tokens
+// that are expired, forged or otherwise unacceptable. The message of such an
+// error is sent to the client as the challenge's error_description; other
+// errors are only logged.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Validator checks a bearer token and returns its claims.
+type Validator interface {
+	Validate(token string) (Claims, error)
+}
+
+// ValidatorFunc adapts a function to Validator, e.g. to look tokens up in a
+// database or ask an introspection endpoint.
+type ValidatorFunc func(token string) (Claims, error)
+
+func (f ValidatorFunc) Validate(token string) (Claims, error) {
+	return f(token)
+}
+
+type BearerConfig struct {
+	// Realm is sent in the challenge; DefaultRealm if empty.
+	Realm string
+	// Tokens checks the tokens, e.g. a JWTValidator.
+	Tokens Validator
+}
+
+// Bearer returns middleware that requires a bearer token (RFC 6750).
+// Requests without one get 401 with a Bearer challenge, and those whose
+// token is rejected get one with error="invalid_token" as well. Accepted
+// requests reach next with the claims available from ClaimsFromContext.
+func Bearer(cfg BearerConfig) func(next server.Handler) server.Handler {
+	realm := realmOrDefault(cfg.Realm)
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			token, ok := credentials(req.Headers.Get("Authorization"), "Bearer")
+			if !ok {
+				challenge(w, false, "Bearer", "realm", realm)
+				return
+			}
+			claims, err := cfg.Tokens.Validate(token)
+			if err != nil {
+				request.LoggerFromContext(req.Context()).Info("invalid bearer token", "category", "auth", "error", err)
+				params := []string{"realm", realm, "error", "invalid_token"}
+				if errors.Is(err, ErrInvalidToken) {
+					params = append(params, "error_description", err.Error())
+				}
+				challenge(w, false, "Bearer", params...)
+				return
+			}
+			if claims == nil {
+				claims = Claims{}
+			}
+			next(w, withUser(req, claims.Subject(), claims))
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package compress
+
+import (
+	"httpfromtcp/internal/negotiate"
+	"strings"
+)
+
+// supported lists the content codings we can produce, in order of
+// preference when the client weights them equally.
+var supported = []string{"gzip", "deflate"}
+
+// negotiateCoding picks a content coding from an Accept-Encoding value
+// (RFC 9110 12.5.3). It returns "" when the client did not ask for
+// compression or rejects every coding we support.
+func negotiateCoding(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	for _, r := range negotiate.ParseList(acceptEncoding) {
+		if r.Value == "x-gzip" {
+			r.Value = "gzip"
+		}
+		weights[r.Value] = r.Q
+	}
+	wildcard, hasWildcard := weights["*"]
+
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		q, ok := weights[coding]
+		if !ok && hasWildcard {
+			q, ok = wildcard, true
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
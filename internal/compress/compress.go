@@ -0,0 +1,142 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMinSize is the smallest fixed-length body that gets compressed when
+// Config.MinSize is zero. Anything smaller rarely benefits from it.
+const DefaultMinSize = 1024
+
+// DefaultContentTypes lists the media types compressed when
+// Config.ContentTypes is empty. Entries ending in "/" match a whole type.
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"image/svg+xml",
+}
+
+type Config struct {
+	// MinSize is the minimum Content-Length worth compressing. Chunked
+	// bodies have no known length and are always compressed.
+	MinSize int
+	// Level is the compression level passed to gzip/zlib; zero selects
+	// the library default.
+	Level int
+	// ContentTypes restricts compression to these media types.
+	ContentTypes []string
+}
+
+// Middleware returns middleware that compresses eligible responses with gzip
+// or deflate, picked from the request's Accept-Encoding. Both fixed-length
+// and chunked responses are supported; a fixed-length response is converted
+// to chunked since its compressed size is not known in advance. A response
+// to HEAD gets the headers the same GET would, and no body.
+func Middleware(cfg Config) func(next server.Handler) server.Handler {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = DefaultMinSize
+	}
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultContentTypes
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			encoding := negotiateCoding(req.Headers.Get("Accept-Encoding"))
+			head := req.RequestLine.Method == "HEAD"
+			w.OnWriteHeaders(func(statusCode response.StatusCode, h headers.Headers) {
+				cfg.apply(w, encoding, head, statusCode, h)
+			})
+			next(w, req)
+		}
+	}
+}
+
+// apply decides, once the handler's headers are known, whether to compress
+// the body and rewrites the headers accordingly. For HEAD the headers are
+// rewritten the same way, but any body the handler writes is dropped.
+func (cfg Config) apply(w *response.Writer, encoding string, head bool, statusCode response.StatusCode, h headers.Headers) {
+	if !bodyAllowed(statusCode) || h.Get("Content-Encoding") != "" || !cfg.compressible(h.Get("Content-Type")) {
+		return
+	}
+
+	// The representation depends on Accept-Encoding whether or not this
+	// particular client gets a compressed one.
+	h.AddVary("Accept-Encoding")
+
+	if encoding == "" || h.Get("Content-Range") != "" {
+		return
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < cfg.MinSize {
+			return
+		}
+	}
+
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", encoding)
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// The compressed bytes differ from the identity ones, so a strong
+		// validator no longer describes them.
+		h.Set("ETag", "W/"+etag)
+	}
+	if head {
+		// Without a body there is nothing to frame, and a last chunk
+		// must not be sent.
+		w.WrapBody(func(io.Writer) io.WriteCloser { return discard{} })
+		return
+	}
+	h.Set("Transfer-Encoding", "chunked")
+
+	level := cfg.Level
+	w.WrapBody(func(dst io.Writer) io.WriteCloser {
+		if encoding == "gzip" {
+			zw, _ := gzip.NewWriterLevel(dst, level)
+			return zw
+		}
+		zw, _ := zlib.NewWriterLevel(dst, level)
+		return zw
+	})
+}
+
+// discard swallows the body of a response to HEAD.
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+func (discard) Close() error                { return nil }
+
+func (cfg Config) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, allowed := range cfg.ContentTypes {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) {
+			return true
+		}
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+func bodyAllowed(statusCode response.StatusCode) bool {
+	return statusCode >= 200 && statusCode != 204 && statusCode != response.StatusNotModified
+}
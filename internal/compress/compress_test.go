@@ -0,0 +1,259 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateCoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.1, deflate;q=0.2", "deflate"},
+		{"identity", ""},
+		{"br, x-gzip", "gzip"},
+		{"gzip;q=2", ""},
+		{"GZIP;Q=0.8", "gzip"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, negotiateCoding(tt.acceptEncoding), "Accept-Encoding: %q", tt.acceptEncoding)
+	}
+}
+
+type testResponse struct {
+	statusLine string
+	headers    headers.Headers
+	body       []byte
+}
+
+// run sends rawRequest through the compression middleware wrapped around
+// handler and decodes the framing of the response.
+func run(t *testing.T, handler server.Handler, rawRequest string) testResponse {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	Middleware(Config{})(handler)(w, req)
+	require.NoError(t, w.Finish())
+
+	r := bufio.NewReader(&buf)
+	statusLine, err := r.ReadString('\n')
+	require.NoError(t, err)
+
+	res := testResponse{statusLine: strings.TrimSpace(statusLine), headers: headers.NewHeaders()}
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		key, value, _ := strings.Cut(line, ": ")
+		res.headers.Set(key, value)
+	}
+
+	if res.headers.Get("Transfer-Encoding") != "chunked" {
+		res.body, err = io.ReadAll(r)
+		require.NoError(t, err)
+		return res
+	}
+
+	for {
+		sizeLine, err := r.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeLine), 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(r, chunk)
+		require.NoError(t, err)
+		require.Equal(t, "\r\n", string(chunk[size:]))
+		res.body = append(res.body, chunk[:size]...)
+	}
+	trailer, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", string(trailer))
+	return res
+}
+
+func fixedHandler(contentType, body string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestMiddleware(t *testing.T) {
+	large := strings.Repeat("<p>hello, compression</p>", 100)
+
+	t.Run("Fixed body is gzipped", func(t *testing.T) {
+		res := run(t, fixedHandler("text/html", large), "GET / HTTP/1.1\r\nAccept-Encoding: gzip, deflate\r\n\r\n")
+		assert.Equal(t, "gzip", res.headers.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", res.headers.Get("Vary"))
+		assert.Empty(t, res.headers.Get("Content-Length"))
+		assert.Less(t, len(res.body), len(large))
+		assert.Equal(t, large, gunzip(t, res.body))
+	})
+
+	t.Run("Deflate", func(t *testing.T) {
+		res := run(t, fixedHandler("application/json", large), "GET / HTTP/1.1\r\nAccept-Encoding: deflate\r\n\r\n")
+		assert.Equal(t, "deflate", res.headers.Get("Content-Encoding"))
+		zr, err := zlib.NewReader(bytes.NewReader(res.body))
+		require.NoError(t, err)
+		out, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, large, string(out))
+	})
+
+	t.Run("Small body is left alone", func(t *testing.T) {
+		res := run(t, fixedHandler("text/html", "<p>hi</p>"), "GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n")
+		assert.Empty(t, res.headers.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", res.headers.Get("Vary"))
+		assert.Equal(t, "<p>hi</p>", string(res.body))
+	})
+
+	t.Run("Ineligible content type", func(t *testing.T) {
+		res := run(t, fixedHandler("image/png", large), "GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n")
+		assert.Empty(t, res.headers.Get("Content-Encoding"))
+		assert.Empty(t, res.headers.Get("Vary"))
+		assert.Equal(t, large, string(res.body))
+	})
+
+	t.Run("Client without Accept-Encoding", func(t *testing.T) {
+		res := run(t, fixedHandler("text/html", large), "GET / HTTP/1.1\r\n\r\n")
+		assert.Empty(t, res.headers.Get("Content-Encoding"))
+		assert.Equal(t, large, string(res.body))
+	})
+
+	t.Run("HEAD gets the headers of GET", func(t *testing.T) {
+		handler := fixedHandler("text/html", large)
+		get := run(t, handler, "GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n")
+		head := run(t, handler, "HEAD / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n")
+		assert.Equal(t, "gzip", head.headers.Get("Content-Encoding"))
+		assert.Equal(t, get.headers.Get("Vary"), head.headers.Get("Vary"))
+		assert.Empty(t, head.headers.Get("Content-Length"))
+		assert.Empty(t, head.body)
+	})
+
+	t.Run("Chunked body", func(t *testing.T) {
+		handler := func(w *response.Writer, req *request.Request) {
+			h := headers.NewHeaders()
+			h.Set("Content-Type", "text/plain")
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteChunkedBody([]byte("first "))
+			w.WriteChunkedBody([]byte("second"))
+			w.WriteChunkedBodyDone()
+		}
+		res := run(t, handler, "GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n")
+		assert.Equal(t, "gzip", res.headers.Get("Content-Encoding"))
+		assert.Equal(t, "first second", gunzip(t, res.body))
+	})
+}
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestDecodeRequests(t *testing.T) {
+	var got *request.Request
+	echo := func(w *response.Writer, req *request.Request) {
+		got = req
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.NewHeaders())
+	}
+	send := func(cfg DecodeConfig, encoding string, body []byte) string {
+		got = nil
+		raw := "POST /telemetry HTTP/1.1\r\nContent-Encoding: " + encoding +
+			"\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		DecodeRequests(cfg)(echo)(response.NewWriter(&buf), req)
+		statusLine, _, _ := strings.Cut(buf.String(), "\r\n")
+		return statusLine
+	}
+
+	t.Run("Gzip body is decoded", func(t *testing.T) {
+		status := send(DecodeConfig{}, "gzip", gzipBytes(t, `{"cpu":0.5}`))
+		assert.Equal(t, "HTTP/1.1 200 OK", status)
+		require.NotNil(t, got)
+		assert.Equal(t, `{"cpu":0.5}`, string(got.Body))
+		assert.Empty(t, got.Headers.Get("Content-Encoding"))
+		assert.Equal(t, "11", got.Headers.Get("Content-Length"))
+	})
+
+	t.Run("Deflate body is decoded", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write([]byte("zlib data"))
+		zw.Close()
+		send(DecodeConfig{}, "deflate", buf.Bytes())
+		require.NotNil(t, got)
+		assert.Equal(t, "zlib data", string(got.Body))
+	})
+
+	t.Run("Unsupported encoding", func(t *testing.T) {
+		status := send(DecodeConfig{}, "br", []byte("whatever"))
+		assert.Equal(t, "HTTP/1.1 415 Unsupported Media Type", status)
+		assert.Nil(t, got)
+	})
+
+	t.Run("Decoded size limit", func(t *testing.T) {
+		bomb := gzipBytes(t, strings.Repeat("A", 64<<10))
+		status := send(DecodeConfig{MaxDecodedSize: 1 << 10}, "gzip", bomb)
+		assert.Equal(t, "HTTP/1.1 413 Content Too Large", status)
+		assert.Nil(t, got)
+	})
+
+	t.Run("Corrupt body", func(t *testing.T) {
+		status := send(DecodeConfig{}, "gzip", []byte("not gzip at all"))
+		assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+		assert.Nil(t, got)
+	})
+}
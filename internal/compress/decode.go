@@ -0,0 +1,126 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxDecodedSize caps a decoded request body when
+// DecodeConfig.MaxDecodedSize is zero.
+const DefaultMaxDecodedSize = 10 << 20
+
+type DecodeConfig struct {
+	// MaxDecodedSize is the largest body, after decoding, that a handler
+	// will receive. Larger bodies are rejected with 413 so that a small
+	// compressed payload cannot expand without bound.
+	MaxDecodedSize int64
+}
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content coding")
+	errTooLarge            = errors.New("decoded body exceeds size limit")
+)
+
+// DecodeRequests returns middleware that transparently decodes request
+// bodies sent with "Content-Encoding: gzip" or "deflate". On success the
+// handler sees the decoded bytes in Request.Body, with Content-Encoding
+// removed and Content-Length updated. Unknown codings get 415, oversized
+// bodies 413 and corrupt ones 400.
+func DecodeRequests(cfg DecodeConfig) func(next server.Handler) server.Handler {
+	if cfg.MaxDecodedSize <= 0 {
+		cfg.MaxDecodedSize = DefaultMaxDecodedSize
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			encoding := req.Headers.Get("Content-Encoding")
+			if encoding == "" || len(req.Body) == 0 {
+				next(w, req)
+				return
+			}
+
+			body, err := decodeBody(req.Body, encoding, cfg.MaxDecodedSize)
+			switch {
+			case errors.Is(err, errUnsupportedEncoding):
+				h := headers.NewHeaders()
+				h.Set("Accept-Encoding", strings.Join(supported, ", "))
+				w.WriteError(response.StatusUnsupportedMediaType, h)
+				return
+			case errors.Is(err, errTooLarge):
+				w.WriteError(response.StatusContentTooLarge, nil)
+				return
+			case err != nil:
+				request.LoggerFromContext(req.Context()).Info("cannot decode request body", "category", "decode", "error", err)
+				w.WriteError(response.StatusBadRequest, nil)
+				return
+			}
+
+			req.Body = body
+			req.Headers.Del("Content-Encoding")
+			req.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+			next(w, req)
+		}
+	}
+}
+
+// decodeBody undoes each coding listed in a Content-Encoding value, last
+// applied first (RFC 9110 8.4).
+func decodeBody(body []byte, encoding string, limit int64) ([]byte, error) {
+	codings := strings.Split(encoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "identity" || coding == "" {
+			continue
+		}
+
+		zr, err := newDecoder(coding, body)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := io.ReadAll(io.LimitReader(zr, limit+1))
+		zr.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", coding, err)
+		}
+		if int64(len(decoded)) > limit {
+			return nil, errTooLarge
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+func newDecoder(coding string, body []byte) (io.ReadCloser, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped (RFC 9110 8.4.1.2), but
+		// some clients send a raw DEFLATE stream; accept both.
+		br := bufio.NewReader(bytes.NewReader(body))
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnsupportedEncoding, coding)
+	}
+}
+
+// isZlibHeader checks the CMF/FLG pair that starts a zlib stream
+// (RFC 1950 2.2).
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
@@ -0,0 +1,200 @@
+package conditional
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxBytes is the largest body Middleware holds back when
+// Config.MaxBytes is zero.
+const DefaultMaxBytes = 1 << 20
+
+// Config controls Middleware. The zero value is usable.
+type Config struct {
+	// Weak marks generated entity tags as weak, for handlers whose output
+	// is equivalent but not byte-for-byte identical between requests.
+	Weak bool
+	// MaxBytes bounds the body held back to compute an entity tag. Larger
+	// responses are streamed unchanged.
+	MaxBytes int
+}
+
+// representationFields describe the body of the 200 response, so they are
+// dropped when it is replaced by a 304 or 412 (RFC 9110 15.4.5).
+var representationFields = []string{
+	"Content-Type", "Content-Length", "Content-Encoding", "Content-Language",
+	"Content-Range", "Transfer-Encoding", "Trailer",
+}
+
+// Middleware makes GET and HEAD responses conditional. It holds back each
+// 200 response, gives it an ETag computed from the body unless the handler
+// set one, and evaluates the request's preconditions against it and any
+// Last-Modified the handler set, answering 304 Not Modified or 412
+// Precondition Failed instead when they call for it. A HEAD request is
+// passed on as a GET so that its ETag matches; the body is never sent.
+//
+// Other methods pass straight through: their handlers must evaluate
+// preconditions with Evaluate before making any change. Install the
+// middleware outside compression so that each content coding gets a tag of
+// its own.
+func Middleware(cfg Config) func(next server.Handler) server.Handler {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			method := req.RequestLine.Method
+			if method != "GET" && method != "HEAD" {
+				next(w, req)
+				return
+			}
+			rec := response.NewRecorder(w, cfg.MaxBytes)
+			inner := req
+			if method == "HEAD" {
+				get := *req
+				get.RequestLine.Method = "GET"
+				inner = &get
+				rec.DiscardBody()
+			}
+
+			next(rec.Writer(), inner)
+			if err := rec.Writer().Finish(); err != nil || rec.Committed() {
+				// Hijacked, streamed or failed; nothing left to send.
+				return
+			}
+			if rec.StatusCode == response.StatusOK && rec.Header != nil {
+				respond(rec, req, cfg)
+			}
+			if method == "HEAD" && rec.StatusCode == response.StatusOK && rec.Header != nil && rec.Header.Get("Content-Length") == "" {
+				// The whole body is known, so a chunked GET response
+				// can tell HEAD its length.
+				rec.Header.Del("Transfer-Encoding")
+				rec.Header.Del("Trailer")
+				rec.Header.Set("Content-Length", strconv.Itoa(rec.Body.Len()))
+			}
+			rec.Commit()
+		}
+	}
+}
+
+// respond tags the recorded 200 response and turns it into a 304 or 412 if
+// the request's preconditions say so.
+func respond(rec *response.Recorder, req *request.Request, cfg Config) {
+	etag := rec.Header.Get("ETag")
+	if etag == "" {
+		etag = makeETag(rec.Body.Bytes(), cfg.Weak)
+		rec.Header.Set("ETag", etag)
+	}
+	lastModified, _ := parseTime(rec.Header.Get("Last-Modified"))
+
+	status := Evaluate(req, etag, lastModified)
+	if status == response.StatusOK {
+		return
+	}
+	rec.StatusCode = status
+	for _, field := range representationFields {
+		rec.Header.Del(field)
+	}
+	rec.Body.Reset()
+	rec.Trailers = nil
+	if status == response.StatusPreconditionFailed {
+		body := "412 Precondition Failed\n"
+		rec.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		rec.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		rec.Body.WriteString(body)
+	}
+}
+
+// makeETag derives an entity tag from the body.
+func makeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// Evaluate checks req's preconditions against the selected
+// representation's entity tag and last modification time, either of which
+// may be unknown (empty or zero), in the order of RFC 9110 13.2.2. It
+// returns StatusOK if the request may proceed, StatusNotModified if a GET
+// or HEAD can be answered from the client's cache, or
+// StatusPreconditionFailed.
+func Evaluate(req *request.Request, etag string, lastModified time.Time) response.StatusCode {
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+	lastModified = lastModified.Truncate(time.Second)
+
+	if im := req.Headers.Get("If-Match"); im != "" {
+		if !etagListMatches(im, etag, true) {
+			return response.StatusPreconditionFailed
+		}
+	} else if ius, ok := parseTime(req.Headers.Get("If-Unmodified-Since")); ok && !lastModified.IsZero() {
+		if lastModified.After(ius) {
+			return response.StatusPreconditionFailed
+		}
+	}
+
+	if inm := req.Headers.Get("If-None-Match"); inm != "" {
+		if etagListMatches(inm, etag, false) {
+			if safe {
+				return response.StatusNotModified
+			}
+			return response.StatusPreconditionFailed
+		}
+	} else if ims, ok := parseTime(req.Headers.Get("If-Modified-Since")); ok && safe && !lastModified.IsZero() {
+		if !lastModified.After(ims) {
+			return response.StatusNotModified
+		}
+	}
+	return response.StatusOK
+}
+
+// etagListMatches checks a comma-separated If-Match/If-None-Match value
+// against etag, using strong or weak comparison (RFC 9110 8.8.3.2). Only "*"
+// matches an unknown tag.
+func etagListMatches(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+		if !strong && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// obsolete date formats that recipients must still accept (RFC 9110 5.6.7).
+var timeFormats = []string{
+	"Mon, 02 Jan 2006 15:04:05 GMT",
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+func parseTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range timeFormats {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,285 @@
+package conditional
+
+import (
+	"bytes"
+	"httpfromtcp/internal/compress"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const lastModified = "Tue, 14 Nov 2023 22:13:20 GMT"
+
+func newRequest(t *testing.T, method, header string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " / HTTP/1.1\r\nHost: localhost\r\n" + header + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestEvaluate(t *testing.T) {
+	modTime, ok := parseTime(lastModified)
+	require.True(t, ok)
+	earlier := "Tue, 14 Nov 2023 22:13:19 GMT"
+	later := "Tue, 14 Nov 2023 22:13:21 GMT"
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		etag   string
+		want   response.StatusCode
+	}{
+		{"no preconditions", "GET", "", `"a"`, response.StatusOK},
+		{"if-match", "PUT", `If-Match: "b", "a"`, `"a"`, response.StatusOK},
+		{"if-match mismatch", "PUT", `If-Match: "b"`, `"a"`, response.StatusPreconditionFailed},
+		{"if-match is strong", "PUT", `If-Match: W/"a"`, `W/"a"`, response.StatusPreconditionFailed},
+		{"if-match any", "PUT", "If-Match: *", "", response.StatusOK},
+		{"if-match unknown tag", "PUT", `If-Match: "a"`, "", response.StatusPreconditionFailed},
+		{"if-unmodified-since", "PUT", "If-Unmodified-Since: " + lastModified, `"a"`, response.StatusOK},
+		{"modified since", "PUT", "If-Unmodified-Since: " + earlier, `"a"`, response.StatusPreconditionFailed},
+		{"if-match over if-unmodified-since", "PUT", "If-Match: \"a\"\r\nIf-Unmodified-Since: " + earlier, `"a"`, response.StatusOK},
+		{"if-none-match", "GET", `If-None-Match: "a"`, `"a"`, response.StatusNotModified},
+		{"if-none-match is weak", "HEAD", `If-None-Match: W/"a"`, `"a"`, response.StatusNotModified},
+		{"if-none-match mismatch", "GET", `If-None-Match: "b"`, `"a"`, response.StatusOK},
+		{"if-none-match unsafe", "PUT", "If-None-Match: *", `"a"`, response.StatusPreconditionFailed},
+		{"if-modified-since", "GET", "If-Modified-Since: " + lastModified, `"a"`, response.StatusNotModified},
+		{"modified", "GET", "If-Modified-Since: " + earlier, `"a"`, response.StatusOK},
+		{"if-none-match over if-modified-since", "GET", "If-None-Match: \"b\"\r\nIf-Modified-Since: " + later, `"a"`, response.StatusOK},
+		{"if-modified-since unsafe", "POST", "If-Modified-Since: " + later, `"a"`, response.StatusOK},
+		{"invalid date", "GET", "If-Modified-Since: yesterday", `"a"`, response.StatusOK},
+		{"if-match before if-none-match", "GET", "If-Match: \"b\"\r\nIf-None-Match: \"a\"", `"a"`, response.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header != "" {
+				header += "\r\n"
+			}
+			assert.Equal(t, tt.want, Evaluate(newRequest(t, tt.method, header), tt.etag, modTime))
+		})
+	}
+
+	// Without a modification time the date preconditions do not apply.
+	req := newRequest(t, "GET", "If-Modified-Since: "+later+"\r\nIf-Unmodified-Since: "+earlier+"\r\n")
+	assert.Equal(t, response.StatusOK, Evaluate(req, "", time.Time{}))
+}
+
+// run sends a request through the middleware wrapped around handler and
+// returns the raw response.
+func run(t *testing.T, cfg Config, handler server.Handler, method, header string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	Middleware(cfg)(handler)(w, newRequest(t, method, header))
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func fixedHandler(body string, extra headers.Headers) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		h := extra.Clone()
+		h.Set("Content-Type", "text/plain")
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		if req.RequestLine.Method != "HEAD" {
+			w.WriteBody([]byte(body))
+		}
+	}
+}
+
+var etagLine = regexp.MustCompile(`(?m)^etag: (.*)\r$`)
+
+func etagOf(t *testing.T, raw string) string {
+	t.Helper()
+	m := etagLine.FindStringSubmatch(raw)
+	require.NotNil(t, m, raw)
+	return m[1]
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := fixedHandler("hello", nil)
+
+	raw := run(t, Config{}, handler, "GET", "")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\nhello"))
+	etag := etagOf(t, raw)
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, etag, etagOf(t, run(t, Config{}, handler, "GET", "")), "same body, same tag")
+	assert.NotEqual(t, etag, etagOf(t, run(t, Config{}, fixedHandler("world", nil), "GET", "")))
+
+	raw = run(t, Config{}, handler, "GET", "If-None-Match: "+etag+"\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, raw, "etag: "+etag+"\r\n")
+	assert.NotContains(t, raw, "content-length")
+	assert.NotContains(t, raw, "content-type")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"))
+
+	raw = run(t, Config{}, handler, "GET", "If-Match: \"other\"\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 412 Precondition Failed\r\n"))
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n412 Precondition Failed\n"))
+
+	// HEAD gets the GET's tag and length but no body.
+	raw = run(t, Config{}, handler, "HEAD", "")
+	assert.Contains(t, raw, "etag: "+etag+"\r\n")
+	assert.Contains(t, raw, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"))
+
+	// A HEAD answered with 304 gets no Content-Length of its own.
+	raw = run(t, Config{}, handler, "HEAD", "If-None-Match: "+etag+"\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.NotContains(t, raw, "content-length")
+
+	raw = run(t, Config{Weak: true}, handler, "GET", "")
+	weak := etagOf(t, raw)
+	assert.Equal(t, "W/"+etag, weak)
+	raw = run(t, Config{Weak: true}, handler, "GET", "If-None-Match: "+etag+"\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 304 Not Modified\r\n"))
+}
+
+func TestMiddlewareHandlerValidators(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("ETag", `"v1"`)
+	h.Set("Last-Modified", lastModified)
+	handler := fixedHandler("hello", h)
+
+	raw := run(t, Config{}, handler, "GET", "")
+	assert.Equal(t, `"v1"`, etagOf(t, raw))
+
+	raw = run(t, Config{}, handler, "GET", "If-Modified-Since: "+lastModified+"\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, raw, "last-modified: "+lastModified+"\r\n")
+
+	raw = run(t, Config{}, handler, "GET", "If-Unmodified-Since: Mon, 13 Nov 2023 00:00:00 GMT\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 412 Precondition Failed\r\n"))
+}
+
+func TestMiddlewarePassesThrough(t *testing.T) {
+	// Other methods, and statuses other than 200, are left alone.
+	raw := run(t, Config{}, fixedHandler("hello", nil), "POST", "If-None-Match: *\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+	assert.NotContains(t, raw, "etag")
+
+	notFound := func(w *response.Writer, req *request.Request) {
+		w.WriteError(response.StatusNotFound, nil)
+	}
+	raw = run(t, Config{}, notFound, "GET", "If-Match: \"x\"\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 404 Not Found\r\n"))
+	assert.NotContains(t, raw, "etag")
+
+	// A body over the limit is streamed unchanged.
+	raw = run(t, Config{MaxBytes: 4}, fixedHandler("hello", nil), "GET", "If-None-Match: *\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\nhello"))
+	assert.NotContains(t, raw, "etag")
+
+	// So is one to HEAD, but without the body.
+	raw = run(t, Config{MaxBytes: 4}, fixedHandler("hello", nil), "HEAD", "")
+	assert.Contains(t, raw, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"), raw)
+}
+
+func TestMiddlewareHijack(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	received := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(clientConn)
+		received <- string(data)
+	}()
+
+	handler := func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Upgrade", "websocket")
+		h.Set("Connection", "Upgrade")
+		w.WriteStatusLine(response.StatusSwitchingProtocols)
+		w.WriteHeaders(h)
+		conn, rw, err := w.Hijack()
+		require.NoError(t, err)
+		rw.WriteString("frame")
+		rw.Flush()
+		conn.Close()
+	}
+	w := response.NewWriter(serverConn)
+	Middleware(Config{})(handler)(w, newRequest(t, "GET", ""))
+	assert.True(t, w.Hijacked())
+
+	raw := <-received
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 101 Switching Protocols\r\n"), raw)
+	assert.Contains(t, raw, "upgrade: websocket\r\n")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\nframe"), raw)
+}
+
+func chunkedHandler(flush bool) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Checksum")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hel"))
+		if flush {
+			w.Flush()
+		}
+		w.WriteChunkedBody([]byte("lo"))
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+	}
+}
+
+func TestMiddlewareChunked(t *testing.T) {
+	const body = "3\r\nhel\r\n2\r\nlo\r\n0\r\nx-checksum: abc\r\n\r\n"
+
+	// A finished chunked body is sent in one chunk, trailers included.
+	raw := run(t, Config{}, chunkedHandler(false), "GET", "")
+	assert.Contains(t, raw, "etag: ")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n5\r\nhello\r\n0\r\nx-checksum: abc\r\n\r\n"), raw)
+
+	raw = run(t, Config{}, chunkedHandler(false), "GET", "If-None-Match: "+etagOf(t, raw)+"\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.NotContains(t, raw, "transfer-encoding")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"))
+
+	raw = run(t, Config{}, chunkedHandler(false), "HEAD", "")
+	assert.Contains(t, raw, "content-length: 5\r\n")
+	assert.NotContains(t, raw, "transfer-encoding")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"))
+
+	// A streamed HEAD response sends neither chunks nor chunked framing.
+	raw = run(t, Config{}, chunkedHandler(true), "HEAD", "")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+	assert.NotContains(t, raw, "transfer-encoding")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"), raw)
+
+	// Flushing streams the response from then on.
+	raw = run(t, Config{}, chunkedHandler(true), "GET", "If-None-Match: *\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+	assert.NotContains(t, raw, "etag")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"+body), raw)
+}
+
+func TestMiddlewareTagsEachCoding(t *testing.T) {
+	handler := compress.Middleware(compress.Config{MinSize: 1})(fixedHandler(strings.Repeat("hello ", 100), nil))
+
+	plain := run(t, Config{}, handler, "GET", "")
+	gzipped := run(t, Config{}, handler, "GET", "Accept-Encoding: gzip\r\n")
+	assert.Contains(t, gzipped, "content-encoding: gzip\r\n")
+	assert.NotEqual(t, etagOf(t, plain), etagOf(t, gzipped))
+
+	raw := run(t, Config{}, handler, "GET", "Accept-Encoding: gzip\r\nIf-None-Match: "+etagOf(t, gzipped)+"\r\n")
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.NotContains(t, raw, "content-encoding")
+	assert.Contains(t, raw, "vary: Accept-Encoding\r\n")
+}
@@ -0,0 +1,193 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFormat is the IMF-fixdate format used for Expires (RFC 6265bis 4.1.1).
+const timeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type SameSite int
+
+const (
+	// SameSiteDefault omits the attribute, leaving the browser's default,
+	// which is Lax in current browsers.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	// SameSiteNone sends the cookie on cross-site requests too. Browsers
+	// only accept it on Secure cookies.
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is a cookie sent by a client in the Cookie header, which only
+// carries Name and Value, or one set by the server with Set-Cookie.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path   string
+	Domain string
+	// Expires is omitted if zero.
+	Expires time.Time
+	// MaxAge is the lifetime in seconds. Zero omits the attribute and a
+	// negative value deletes the cookie (Max-Age=0).
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned keys the cookie to the top-level site it was set under
+	// (CHIPS). It requires Secure.
+	Partitioned bool
+}
+
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+// Valid reports why c cannot be sent in a Set-Cookie header, wrapping
+// ErrInvalidCookie, or returns nil. Besides the syntax it checks the rules
+// browsers enforce: SameSite=None and Partitioned need Secure, and the
+// __Secure- and __Host- name prefixes their attributes.
+func (c *Cookie) Valid() error {
+	switch {
+	case !headers.IsToken(c.Name):
+		return fmt.Errorf("%w: name %q is not a token", ErrInvalidCookie, c.Name)
+	case !validValue(c.Value):
+		return fmt.Errorf("%w: value of %s has characters not allowed in a cookie", ErrInvalidCookie, c.Name)
+	case !validAttribute(c.Path):
+		return fmt.Errorf("%w: path of %s has characters not allowed in a cookie", ErrInvalidCookie, c.Name)
+	case !validDomain(c.Domain):
+		return fmt.Errorf("%w: domain %q of %s is not a host name", ErrInvalidCookie, c.Domain, c.Name)
+	case !c.Expires.IsZero() && c.Expires.Year() < 1601:
+		return fmt.Errorf("%w: expiry of %s is before 1601", ErrInvalidCookie, c.Name)
+	case c.SameSite == SameSiteNone && !c.Secure:
+		return fmt.Errorf("%w: %s has SameSite=None but is not Secure", ErrInvalidCookie, c.Name)
+	case c.Partitioned && !c.Secure:
+		return fmt.Errorf("%w: %s is Partitioned but not Secure", ErrInvalidCookie, c.Name)
+	case strings.HasPrefix(strings.ToLower(c.Name), "__secure-") && !c.Secure:
+		return fmt.Errorf("%w: %s must be Secure", ErrInvalidCookie, c.Name)
+	case strings.HasPrefix(strings.ToLower(c.Name), "__host-") && (!c.Secure || c.Path != "/" || c.Domain != ""):
+		return fmt.Errorf("%w: %s must be Secure, with Path=/ and no Domain", ErrInvalidCookie, c.Name)
+	}
+	return nil
+}
+
+// String serializes c as the value of a Set-Cookie header (RFC 6265bis
+// 4.1). It does not check c; see Valid.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(timeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Parse reads the cookies in a Cookie request header. Pairs that are not
+// well formed are skipped rather than failing the whole header, as
+// browsers may send cookies set by other servers on the same host. Quotes
+// around a value are removed.
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range strings.Split(header, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !headers.IsToken(name) {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if !validValue(value) {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// validValue reports whether s consists of cookie-octets, optionally in
+// double quotes.
+func validValue(s string) bool {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// validAttribute reports whether s can be an attribute value: no control
+// characters or semicolons.
+func validAttribute(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c == 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func validDomain(s string) bool {
+	if s == "" {
+		return true
+	}
+	s = strings.TrimPrefix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		cookie Cookie
+		want   string
+	}{
+		{Cookie{Name: "id", Value: "abc"}, "id=abc"},
+		{Cookie{Name: "empty"}, "empty="},
+		{
+			Cookie{
+				Name: "session", Value: `"a1b2"`, Path: "/app", Domain: ".example.com",
+				Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+				MaxAge:  3600, Secure: true, HttpOnly: true, SameSite: SameSiteStrict, Partitioned: true,
+			},
+			`session="a1b2"; Path=/app; Domain=example.com; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict; Partitioned`,
+		},
+		{Cookie{Name: "gone", MaxAge: -1}, "gone=; Max-Age=0"},
+		{Cookie{Name: "lax", Value: "1", SameSite: SameSiteLax}, "lax=1; SameSite=Lax"},
+	}
+	for _, tt := range tests {
+		assert.NoError(t, tt.cookie.Valid(), tt.want)
+		assert.Equal(t, tt.want, tt.cookie.String())
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie Cookie
+	}{
+		{"empty name", Cookie{Value: "x"}},
+		{"separator in name", Cookie{Name: "a;b"}},
+		{"space in value", Cookie{Name: "a", Value: "b c"}},
+		{"comma in value", Cookie{Name: "a", Value: "b,c"}},
+		{"lone quote", Cookie{Name: "a", Value: `"b`}},
+		{"semicolon in path", Cookie{Name: "a", Path: "/x;Domain=evil"}},
+		{"bad domain", Cookie{Name: "a", Domain: "exa mple.com"}},
+		{"ancient expiry", Cookie{Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{"SameSite=None without Secure", Cookie{Name: "a", SameSite: SameSiteNone}},
+		{"Partitioned without Secure", Cookie{Name: "a", Partitioned: true}},
+		{"__Secure- without Secure", Cookie{Name: "__Secure-a"}},
+		{"__Host- with Domain", Cookie{Name: "__Host-a", Secure: true, Path: "/", Domain: "example.com"}},
+		{"__Host- without Path=/", Cookie{Name: "__Host-a", Secure: true}},
+	}
+	for _, tt := range tests {
+		assert.ErrorIs(t, tt.cookie.Valid(), ErrInvalidCookie, tt.name)
+	}
+
+	assert.NoError(t, (&Cookie{Name: "__Host-a", Secure: true, Path: "/", SameSite: SameSiteNone}).Valid())
+}
+
+func TestParse(t *testing.T) {
+	cookies := Parse(`a=1; b="two";c=; bad name=x; d=3=4; noequals; e=x y; f=z`)
+	var got []string
+	for _, c := range cookies {
+		got = append(got, c.Name+"="+c.Value)
+	}
+	assert.Equal(t, []string{"a=1", "b=two", "c=", "d=3=4", "f=z"}, got)
+
+	assert.Empty(t, Parse(""))
+}
@@ -0,0 +1,216 @@
+package cors
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAllowedMethods are allowed when Config.AllowedMethods is empty.
+var DefaultAllowedMethods = []string{"GET", "HEAD", "POST"}
+
+// safelistedMethods may always be used; browsers send them without asking.
+var safelistedMethods = []string{"GET", "HEAD", "POST"}
+
+type Config struct {
+	// AllowedOrigins lists the origins that may make cross-origin
+	// requests: exact origins such as "https://app.example.com", patterns
+	// with a single "*" such as "https://*.example.com", or "*" for any
+	// origin.
+	AllowedOrigins []string
+	// AllowedOriginPatterns are regular expressions an origin may match
+	// instead. Each must match the whole origin.
+	AllowedOriginPatterns []string
+	// AllowedMethods are the methods a preflight may ask for.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers a preflight may ask for,
+	// beyond the CORS-safelisted ones; "*" allows any.
+	AllowedHeaders []string
+	// ExposedHeaders are response headers scripts may read, beyond the
+	// CORS-safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and HTTP
+	// authentication. The origin is then always echoed, since browsers
+	// refuse credentials with "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight result; zero
+	// leaves it to the browser.
+	MaxAge time.Duration
+}
+
+type policy struct {
+	Config
+	anyOrigin  bool
+	origins    []string
+	wildcards  [][2]string
+	patterns   []*regexp.Regexp
+	anyHeader  bool
+	methods    string
+	exposed    string
+	maxAge     string
+	varyOrigin bool
+}
+
+// Middleware returns middleware implementing Cross-Origin Resource Sharing.
+// Preflight requests (OPTIONS with Origin and
+// Access-Control-Request-Method) are answered with 204 without calling
+// next; the answer only carries the Access-Control-Allow-* headers if the
+// origin, method and headers are all allowed. Other requests from an
+// allowed origin have Access-Control-Allow-Origin and the related headers
+// added to their response. Vary: Origin is set whenever the response
+// depends on the origin. It panics if a pattern does not compile.
+func Middleware(cfg Config) func(next server.Handler) server.Handler {
+	p := newPolicy(cfg)
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			origin := req.Headers.Get("Origin")
+			if req.RequestLine.Method == "OPTIONS" && origin != "" && req.Headers.Get("Access-Control-Request-Method") != "" {
+				p.preflight(w, req, origin)
+				return
+			}
+			w.OnWriteHeaders(func(statusCode response.StatusCode, h headers.Headers) {
+				p.actual(h, origin)
+			})
+			next(w, req)
+		}
+	}
+}
+
+func newPolicy(cfg Config) *policy {
+	p := &policy{Config: cfg}
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Count(origin, "*") == 1:
+			prefix, suffix, _ := strings.Cut(origin, "*")
+			p.wildcards = append(p.wildcards, [2]string{prefix, suffix})
+		default:
+			p.origins = append(p.origins, origin)
+		}
+	}
+	for _, pattern := range cfg.AllowedOriginPatterns {
+		p.patterns = append(p.patterns, regexp.MustCompile(`^(?:`+pattern+`)$`))
+	}
+
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultAllowedMethods
+	}
+	p.AllowedMethods = methods
+	p.methods = strings.Join(methods, ", ")
+	p.anyHeader = slices.Contains(cfg.AllowedHeaders, "*")
+	p.exposed = strings.Join(cfg.ExposedHeaders, ", ")
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+	// Only a blanket "*" answer is the same for every origin.
+	p.varyOrigin = !p.anyOrigin || cfg.AllowCredentials
+	return p
+}
+
+func (p *policy) allowedOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if p.anyOrigin {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if slices.Contains(p.origins, lower) {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if len(lower) > len(w[0])+len(w[1]) && strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) {
+			return true
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin sets the headers shared by preflight and actual responses.
+func (p *policy) allowOrigin(h headers.Headers, origin string) {
+	if p.anyOrigin && !p.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p *policy) actual(h headers.Headers, origin string) {
+	if p.varyOrigin {
+		h.AddVary("Origin")
+	}
+	if !p.allowedOrigin(origin) {
+		return
+	}
+	p.allowOrigin(h, origin)
+	if p.exposed != "" {
+		h.Set("Access-Control-Expose-Headers", p.exposed)
+	}
+}
+
+func (p *policy) preflight(w *response.Writer, req *request.Request, origin string) {
+	h := headers.NewHeaders()
+	h.AddVary("Origin")
+	h.AddVary("Access-Control-Request-Method")
+	h.AddVary("Access-Control-Request-Headers")
+
+	method := req.Headers.Get("Access-Control-Request-Method")
+	requested := req.Headers.Get("Access-Control-Request-Headers")
+	if p.allowedOrigin(origin) && p.allowedMethod(method) && p.allowedHeaders(requested) {
+		p.allowOrigin(h, origin)
+		h.Set("Access-Control-Allow-Methods", p.methods)
+		if requested != "" {
+			h.Set("Access-Control-Allow-Headers", requested)
+		}
+		if p.maxAge != "" {
+			h.Set("Access-Control-Max-Age", p.maxAge)
+		}
+	} else {
+		request.LoggerFromContext(req.Context()).Debug("cors preflight refused", "category", "cors",
+			"origin", origin, "method", method, "headers", requested)
+	}
+
+	if err := w.WriteStatusLine(response.StatusNoContent); err != nil {
+		return
+	}
+	w.WriteHeaders(h)
+}
+
+func (p *policy) allowedMethod(method string) bool {
+	return slices.Contains(safelistedMethods, method) || slices.Contains(p.AllowedMethods, method)
+}
+
+func (p *policy) allowedHeaders(requested string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.ContainsFunc(p.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, name)
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package cors
+
+import (
+	"bufio"
+	"bytes"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testResponse struct {
+	statusLine string
+	headers    headers.Headers
+	called     bool
+}
+
+// run sends rawRequest through the CORS middleware configured by cfg and
+// parses the response head.
+func run(t *testing.T, cfg Config, rawRequest string) testResponse {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+	require.NoError(t, err)
+
+	var res testResponse
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	Middleware(cfg)(func(w *response.Writer, req *request.Request) {
+		res.called = true
+		h := headers.NewHeaders()
+		h.Set("Vary", "Accept-Encoding")
+		w.WriteError(response.StatusOK, h)
+	})(w, req)
+	require.NoError(t, w.Finish())
+
+	reader := bufio.NewReader(&buf)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	res.statusLine = strings.TrimSpace(line)
+	res.headers = headers.NewHeaders()
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		name, value, _ := strings.Cut(strings.TrimSpace(line), ": ")
+		res.headers.Set(name, value)
+	}
+	return res
+}
+
+func get(origin string) string {
+	return "GET / HTTP/1.1\r\nOrigin: " + origin + "\r\n\r\n"
+}
+
+func preflight(origin, method, requestHeaders string) string {
+	raw := "OPTIONS /api HTTP/1.1\r\nOrigin: " + origin + "\r\nAccess-Control-Request-Method: " + method + "\r\n"
+	if requestHeaders != "" {
+		raw += "Access-Control-Request-Headers: " + requestHeaders + "\r\n"
+	}
+	return raw + "\r\n"
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	cfg := Config{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []string{`https://pr-\d+\.preview\.example\.net`},
+	}
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"http://app.example.com", false},
+		{"https://app.example.com.evil.com", false},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://.example.org", false},
+		{"https://example.org", false},
+		{"https://pr-12.preview.example.net", true},
+		{"https://pr-12.preview.example.net.evil.com", false},
+		{"https://pr-x.preview.example.net", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		res := run(t, cfg, get(tt.origin))
+		assert.True(t, res.called)
+		assert.Equal(t, "Accept-Encoding, Origin", res.headers.Get("Vary"), tt.origin)
+		if tt.allowed {
+			assert.Equal(t, tt.origin, res.headers.Get("Access-Control-Allow-Origin"), tt.origin)
+		} else {
+			assert.Empty(t, res.headers.Get("Access-Control-Allow-Origin"), tt.origin)
+		}
+	}
+}
+
+func TestAnyOrigin(t *testing.T) {
+	res := run(t, Config{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"X-Total", "ETag"}}, get("https://a.test"))
+	assert.Equal(t, "*", res.headers.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Total, ETag", res.headers.Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "Accept-Encoding", res.headers.Get("Vary"))
+	assert.Empty(t, res.headers.Get("Access-Control-Allow-Credentials"))
+
+	// Credentials rule out "*", so the origin is echoed and varies.
+	res = run(t, Config{AllowedOrigins: []string{"*"}, AllowCredentials: true}, get("https://a.test"))
+	assert.Equal(t, "https://a.test", res.headers.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", res.headers.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Accept-Encoding, Origin", res.headers.Get("Vary"))
+
+	res = run(t, Config{AllowedOrigins: []string{"*"}}, "GET / HTTP/1.1\r\n\r\n")
+	assert.Empty(t, res.headers.Get("Access-Control-Allow-Origin"))
+}
+
+func TestPreflight(t *testing.T) {
+	cfg := Config{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+
+	res := run(t, cfg, preflight("https://app.example.com", "PUT", "content-type, authorization"))
+	assert.False(t, res.called)
+	assert.Equal(t, "HTTP/1.1 204 No Content", res.statusLine)
+	assert.Equal(t, "https://app.example.com", res.headers.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, PUT, DELETE", res.headers.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, authorization", res.headers.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", res.headers.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", res.headers.Get("Access-Control-Max-Age"))
+	assert.Equal(t, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers", res.headers.Get("Vary"))
+	assert.Empty(t, res.headers.Get("Content-Length"))
+
+	// Safelisted methods need not be listed.
+	res = run(t, cfg, preflight("https://app.example.com", "POST", ""))
+	assert.Equal(t, "https://app.example.com", res.headers.Get("Access-Control-Allow-Origin"))
+
+	for _, raw := range []string{
+		preflight("https://evil.example.com", "PUT", ""),
+		preflight("https://app.example.com", "PATCH", ""),
+		preflight("https://app.example.com", "PUT", "Content-Type, X-Secret"),
+	} {
+		res := run(t, cfg, raw)
+		assert.False(t, res.called)
+		assert.Equal(t, "HTTP/1.1 204 No Content", res.statusLine)
+		assert.Empty(t, res.headers.Get("Access-Control-Allow-Origin"), raw)
+		assert.Empty(t, res.headers.Get("Access-Control-Allow-Methods"), raw)
+	}
+
+	res = run(t, Config{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}},
+		preflight("https://a.test", "GET", "X-Anything"))
+	assert.Equal(t, "*", res.headers.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Anything", res.headers.Get("Access-Control-Allow-Headers"))
+
+	// A plain OPTIONS request is not a preflight.
+	res = run(t, cfg, "OPTIONS / HTTP/1.1\r\nOrigin: https://app.example.com\r\n\r\n")
+	assert.True(t, res.called)
+}
@@ -0,0 +1,107 @@
+package fileserver
+
+import (
+	"httpfromtcp/internal/request"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFormat is the IMF-fixdate format from RFC 9110 (5.6.7).
+const timeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsolete date formats that recipients must still accept (RFC 9110 5.6.7).
+var timeFormats = []string{
+	timeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(value string) (time.Time, bool) {
+	for _, layout := range timeFormats {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// makeETag builds a validator from the file's modification time and size.
+func makeETag(info os.FileInfo) string {
+	return `"` + strconv.FormatInt(info.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(info.Size(), 16) + `"`
+}
+
+// notModified reports whether a GET or HEAD request can be answered with 304
+// Not Modified. If-None-Match takes precedence over If-Modified-Since
+// (RFC 9110 13.2.2).
+func notModified(req *request.Request, etag string, modTime time.Time) bool {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		return false
+	}
+
+	if inm := req.Headers.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag, false)
+	}
+
+	ims := req.Headers.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+	t, ok := parseTime(ims)
+	if !ok {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// rangeApplies evaluates If-Range: the Range header is only honoured when the
+// validator it carries still matches the current representation.
+func rangeApplies(req *request.Request, etag string, modTime time.Time) bool {
+	ir := strings.TrimSpace(req.Headers.Get("If-Range"))
+	if ir == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return etagStrongMatch(ir, etag)
+	}
+
+	t, ok := parseTime(ir)
+	if !ok {
+		return false
+	}
+	return modTime.Truncate(time.Second).Equal(t)
+}
+
+// etagListMatches checks a comma-separated If-Match/If-None-Match value
+// against etag, using strong or weak comparison (RFC 9110 8.8.3.2).
+func etagListMatches(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && etagStrongMatch(candidate, etag) {
+			return true
+		}
+		if !strong && etagWeakMatch(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+func etagStrongMatch(a, b string) bool {
+	return a == b && !strings.HasPrefix(a, "W/")
+}
+
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
@@ -0,0 +1,300 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const indexFile = "index.html"
+
+type fileHandler struct {
+	root string
+}
+
+// Handler returns a server.Handler that serves the files found under root.
+// The request target is cleaned and resolved relative to root; paths that
+// would escape root (including through symlinks) are rejected with 403.
+func Handler(root string) server.Handler {
+	fh := &fileHandler{root: root}
+	return fh.serve
+}
+
+func (fh *fileHandler) serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		h := headers.NewHeaders()
+		h.Set("Allow", "GET, HEAD")
+		w.WriteError(response.StatusMethodNotAllowed, h)
+		return
+	}
+
+	urlPath, err := targetPath(req.RequestLine.RequestTarget)
+	if err != nil {
+		w.WriteError(response.StatusBadRequest, nil)
+		return
+	}
+
+	name, err := fh.resolve(urlPath)
+	if err != nil {
+		if errors.Is(err, errOutsideRoot) {
+			w.WriteError(response.StatusForbidden, nil)
+		} else if errors.Is(err, fs.ErrNotExist) {
+			w.WriteError(response.StatusNotFound, nil)
+		} else {
+			request.LoggerFromContext(req.Context()).Error("cannot resolve file", "category", "fs", "path", urlPath, "error", err)
+			w.WriteError(response.StatusInternalServerError, nil)
+		}
+		return
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		w.WriteError(response.StatusNotFound, nil)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(urlPath, "/") {
+			h := headers.NewHeaders()
+			h.Set("Location", path.Base(urlPath)+"/")
+			w.WriteError(response.StatusMovedPermanently, h)
+			return
+		}
+
+		index := filepath.Join(name, indexFile)
+		if indexInfo, err := os.Stat(index); err == nil && !indexInfo.IsDir() {
+			fh.serveFile(w, req, index, indexInfo)
+			return
+		}
+
+		fh.serveDir(w, req, urlPath, name)
+		return
+	}
+
+	if strings.HasSuffix(urlPath, "/") {
+		w.WriteError(response.StatusNotFound, nil)
+		return
+	}
+
+	fh.serveFile(w, req, name, info)
+}
+
+var errOutsideRoot = errors.New("path escapes root directory")
+
+// targetPath extracts the decoded, cleaned path from an origin-form request
+// target, dropping any query string.
+func targetPath(target string) (string, error) {
+	if i := strings.IndexByte(target, '?'); i != -1 {
+		target = target[:i]
+	}
+	if !strings.HasPrefix(target, "/") {
+		return "", fmt.Errorf("request target %q is not in origin-form", target)
+	}
+
+	decoded, err := url.PathUnescape(target)
+	if err != nil {
+		return "", err
+	}
+	if strings.IndexByte(decoded, 0) != -1 {
+		return "", fmt.Errorf("request target contains NUL byte")
+	}
+
+	cleaned := path.Clean(decoded)
+	if strings.HasSuffix(decoded, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+// resolve maps a cleaned URL path onto the filesystem and verifies that the
+// result, after following symlinks, still lives inside the root directory.
+func (fh *fileHandler) resolve(urlPath string) (string, error) {
+	root, err := filepath.Abs(fh.root)
+	if err != nil {
+		return "", err
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+urlPath)))
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	return resolved, nil
+}
+
+func (fh *fileHandler) serveFile(w *response.Writer, req *request.Request, name string, info os.FileInfo) {
+	f, err := os.Open(name)
+	if err != nil {
+		w.WriteError(response.StatusForbidden, nil)
+		return
+	}
+	defer f.Close()
+
+	modTime := info.ModTime()
+	etag := makeETag(info)
+
+	h := headers.NewHeaders()
+	h.Set("Last-Modified", formatTime(modTime))
+	h.Set("ETag", etag)
+	h.Set("Accept-Ranges", "bytes")
+
+	if notModified(req, etag, modTime) {
+		w.WriteStatusLine(response.StatusNotModified)
+		w.WriteHeaders(h)
+		return
+	}
+
+	contentType, err := contentTypeOf(name, f)
+	if err != nil {
+		w.WriteError(response.StatusInternalServerError, nil)
+		return
+	}
+
+	size := info.Size()
+	ranges, err := parseRange(req.Headers.Get("Range"), size)
+	if err != nil {
+		h.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		w.WriteError(response.StatusRequestedRangeNotSatisfiable, h)
+		return
+	}
+	if !rangeApplies(req, etag, modTime) || sumRangesSize(ranges) > size {
+		ranges = nil
+	}
+
+	sendBody := req.RequestLine.Method != "HEAD"
+
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		if err := w.WriteStatusLine(response.StatusOK); err != nil {
+			return
+		}
+		if err := w.WriteHeaders(h); err != nil || !sendBody {
+			return
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			logWriteError(req, err)
+		}
+
+	case 1:
+		ra := ranges[0]
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Range", ra.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		if err := w.WriteStatusLine(response.StatusPartialContent); err != nil {
+			return
+		}
+		if err := w.WriteHeaders(h); err != nil || !sendBody {
+			return
+		}
+		if _, err := f.Seek(ra.start, io.SeekStart); err != nil {
+			return
+		}
+		if _, err := io.CopyN(w, f, ra.length); err != nil {
+			logWriteError(req, err)
+		}
+
+	default:
+		boundary := randomBoundary()
+		h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		h.Set("Content-Length", strconv.FormatInt(multipartLength(ranges, contentType, size, boundary), 10))
+		if err := w.WriteStatusLine(response.StatusPartialContent); err != nil {
+			return
+		}
+		if err := w.WriteHeaders(h); err != nil || !sendBody {
+			return
+		}
+		if err := writeMultipart(w, f, ranges, contentType, size, boundary); err != nil {
+			logWriteError(req, err)
+		}
+	}
+}
+
+// logWriteError notes a body that could not be sent in full, usually
+// because the client went away.
+func logWriteError(req *request.Request, err error) {
+	request.LoggerFromContext(req.Context()).Debug("cannot write body", "category", "write", "error", err)
+}
+
+func (fh *fileHandler) serveDir(w *response.Writer, req *request.Request, urlPath, name string) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		w.WriteError(response.StatusForbidden, nil)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var b strings.Builder
+	title := html.EscapeString(urlPath)
+	fmt.Fprintf(&b, "<html><head><title>Index of %s</title></head><body><h1>Index of %s</h1><ul>", title, title)
+	if urlPath != "/" {
+		b.WriteString(`<li><a href="../">../</a></li>`)
+	}
+	for _, entry := range entries {
+		display := entry.Name()
+		if entry.IsDir() {
+			display += "/"
+		}
+		href := (&url.URL{Path: display}).EscapedPath()
+		if strings.Contains(display, ":") {
+			href = "./" + href
+		}
+		fmt.Fprintf(&b, `<li><a href="%s">%s</a></li>`, html.EscapeString(href), html.EscapeString(display))
+	}
+	b.WriteString("</ul></body></html>")
+	body := b.String()
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil || req.RequestLine.Method == "HEAD" {
+		return
+	}
+	w.WriteBody([]byte(body))
+}
+
+// contentTypeOf picks a media type from the file extension, falling back to
+// sniffing the first bytes of the file. The file offset is reset afterwards.
+func contentTypeOf(name string, f *os.File) (string, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return detectContentType(buf[:n]), nil
+}
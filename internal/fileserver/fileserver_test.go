@@ -0,0 +1,200 @@
+package fileserver
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testResponse struct {
+	statusLine string
+	headers    map[string]string
+	body       string
+}
+
+// serve runs the file handler for root against a raw request and splits the
+// raw response into its parts.
+func serve(t *testing.T, root, rawRequest string) testResponse {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	Handler(root)(response.NewWriter(&buf), req)
+
+	head, body, found := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, found, "response has no header terminator: %q", buf.String())
+
+	lines := strings.Split(head, "\r\n")
+	res := testResponse{statusLine: lines[0], headers: map[string]string{}, body: body}
+	for _, line := range lines[1:] {
+		key, value, _ := strings.Cut(line, ": ")
+		res.headers[key] = value
+	}
+	return res
+}
+
+func newTestRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello, world"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "blob"), []byte("\x89PNG\r\n\x1a\nrest"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a<b>.md"), []byte("# doc"), 0o644))
+	return root
+}
+
+func TestServeFile(t *testing.T) {
+	root := newTestRoot(t)
+
+	t.Run("Full file", func(t *testing.T) {
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 200 OK", res.statusLine)
+		assert.Equal(t, "text/plain; charset=utf-8", res.headers["content-type"])
+		assert.Equal(t, "12", res.headers["content-length"])
+		assert.NotEmpty(t, res.headers["etag"])
+		assert.NotEmpty(t, res.headers["last-modified"])
+		assert.Equal(t, "hello, world", res.body)
+	})
+
+	t.Run("HEAD omits body", func(t *testing.T) {
+		res := serve(t, root, "HEAD /hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 200 OK", res.statusLine)
+		assert.Equal(t, "12", res.headers["content-length"])
+		assert.Empty(t, res.body)
+	})
+
+	t.Run("Sniffed content type", func(t *testing.T) {
+		res := serve(t, root, "GET /blob HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, "image/png", res.headers["content-type"])
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		res := serve(t, root, "GET /nope.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 404 Not Found", res.statusLine)
+	})
+
+	t.Run("Method not allowed", func(t *testing.T) {
+		res := serve(t, root, "POST /hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 405 Method Not Allowed", res.statusLine)
+		assert.Equal(t, "GET, HEAD", res.headers["allow"])
+	})
+}
+
+func TestTraversal(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(parent, "secret.txt"), filepath.Join(root, "link.txt")))
+
+	res := serve(t, root, "GET /../secret.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found", res.statusLine)
+
+	res = serve(t, root, "GET /%2e%2e/secret.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found", res.statusLine)
+
+	res = serve(t, root, "GET /link.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 403 Forbidden", res.statusLine)
+	assert.NotContains(t, res.body, "secret")
+}
+
+func TestDirectory(t *testing.T) {
+	root := newTestRoot(t)
+
+	res := serve(t, root, "GET /docs HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 301 Moved Permanently", res.statusLine)
+	assert.Equal(t, "docs/", res.headers["location"])
+
+	res = serve(t, root, "GET /docs/ HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", res.statusLine)
+	assert.Contains(t, res.body, `<a href="a%3Cb%3E.md">a&lt;b&gt;.md</a>`)
+	assert.Contains(t, res.body, `<a href="../">`)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "index.html"), []byte("<p>index</p>"), 0o644))
+	res = serve(t, root, "GET /docs/ HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "text/html; charset=utf-8", res.headers["content-type"])
+	assert.Equal(t, "<p>index</p>", res.body)
+}
+
+func TestConditional(t *testing.T) {
+	root := newTestRoot(t)
+	res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	etag := res.headers["etag"]
+	lastModified := res.headers["last-modified"]
+
+	t.Run("If-None-Match", func(t *testing.T) {
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nIf-None-Match: \"other\", W/"+etag+"\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 304 Not Modified", res.statusLine)
+		assert.Empty(t, res.body)
+	})
+
+	t.Run("If-Modified-Since", func(t *testing.T) {
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nIf-Modified-Since: "+lastModified+"\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 304 Not Modified", res.statusLine)
+
+		past := formatTime(time.Now().Add(-48 * time.Hour))
+		res = serve(t, root, "GET /hello.txt HTTP/1.1\r\nIf-Modified-Since: "+past+"\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 200 OK", res.statusLine)
+	})
+
+	t.Run("If-None-Match wins over If-Modified-Since", func(t *testing.T) {
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nIf-None-Match: \"other\"\r\nIf-Modified-Since: "+lastModified+"\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 200 OK", res.statusLine)
+	})
+}
+
+func TestRange(t *testing.T) {
+	root := newTestRoot(t)
+
+	t.Run("Single range", func(t *testing.T) {
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nRange: bytes=0-4\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 206 Partial Content", res.statusLine)
+		assert.Equal(t, "bytes 0-4/12", res.headers["content-range"])
+		assert.Equal(t, "5", res.headers["content-length"])
+		assert.Equal(t, "hello", res.body)
+	})
+
+	t.Run("Suffix range", func(t *testing.T) {
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nRange: bytes=-5\r\n\r\n")
+		assert.Equal(t, "bytes 7-11/12", res.headers["content-range"])
+		assert.Equal(t, "world", res.body)
+	})
+
+	t.Run("Unsatisfiable", func(t *testing.T) {
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nRange: bytes=50-\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 416 Range Not Satisfiable", res.statusLine)
+		assert.Equal(t, "bytes */12", res.headers["content-range"])
+	})
+
+	t.Run("Multiple ranges", func(t *testing.T) {
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nRange: bytes=0-1, 7-8\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 206 Partial Content", res.statusLine)
+		ctype := res.headers["content-type"]
+		require.True(t, strings.HasPrefix(ctype, "multipart/byteranges; boundary="))
+		assert.Equal(t, res.headers["content-length"], strconv.Itoa(len(res.body)))
+		assert.Contains(t, res.body, "Content-Range: bytes 0-1/12\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nhe")
+		assert.Contains(t, res.body, "Content-Range: bytes 7-8/12\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nwo")
+	})
+
+	t.Run("If-Range mismatch serves full file", func(t *testing.T) {
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nRange: bytes=0-4\r\nIf-Range: \"stale\"\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 200 OK", res.statusLine)
+		assert.Equal(t, "hello, world", res.body)
+	})
+
+	t.Run("If-Range match serves range", func(t *testing.T) {
+		etag := serve(t, root, "HEAD /hello.txt HTTP/1.1\r\n\r\n").headers["etag"]
+		res := serve(t, root, "GET /hello.txt HTTP/1.1\r\nRange: bytes=0-4\r\nIf-Range: "+etag+"\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 206 Partial Content", res.statusLine)
+	})
+}
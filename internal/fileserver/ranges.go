@@ -0,0 +1,157 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// byteRange is a resolved, in-bounds slice of a file.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header value (RFC 9110 14.2) against a
+// representation of the given size. An empty header, an unknown range unit or
+// a syntactically invalid value yields no ranges, meaning the whole file is
+// served. errUnsatisfiableRange is returned when the header is valid but none
+// of its ranges overlap the file.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	const prefix = "bytes="
+	if !strings.HasPrefix(value, prefix) {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	for _, spec := range strings.Split(value[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		dash := strings.IndexByte(spec, '-')
+		if dash == -1 {
+			return nil, nil
+		}
+		startStr, endStr := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+		var r byteRange
+		if startStr == "" {
+			// suffix-range: the last N bytes
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if endStr != "" {
+				end, err = strconv.ParseInt(endStr, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+func sumRangesSize(ranges []byteRange) int64 {
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	return total
+}
+
+func randomBoundary() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+func partHeader(r byteRange, contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// countingWriter discards everything written to it, keeping only the count.
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// multipartLength computes the exact size of the multipart/byteranges body
+// that writeMultipart will produce, so Content-Length can be sent up front.
+func multipartLength(ranges []byteRange, contentType string, size int64, boundary string) int64 {
+	cw := &countingWriter{}
+	mw := multipart.NewWriter(cw)
+	mw.SetBoundary(boundary)
+	for _, r := range ranges {
+		mw.CreatePart(partHeader(r, contentType, size))
+		cw.n += r.length
+	}
+	mw.Close()
+	return cw.n
+}
+
+func writeMultipart(w io.Writer, f io.ReadSeeker, ranges []byteRange, contentType string, size int64, boundary string) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		part, err := mw.CreatePart(partHeader(r, contentType, size))
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(part, f, r.length); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
@@ -0,0 +1,77 @@
+package fileserver
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// sniffLen is how many leading bytes detectContentType looks at.
+const sniffLen = 512
+
+var signatures = []struct {
+	prefix      []byte
+	contentType string
+}{
+	{[]byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{[]byte("\xff\xd8\xff"), "image/jpeg"},
+	{[]byte("GIF87a"), "image/gif"},
+	{[]byte("GIF89a"), "image/gif"},
+	{[]byte("%PDF-"), "application/pdf"},
+	{[]byte("PK\x03\x04"), "application/zip"},
+	{[]byte("\x1f\x8b\x08"), "application/x-gzip"},
+	{[]byte("\x00asm"), "application/wasm"},
+	{[]byte("wOFF"), "font/woff"},
+	{[]byte("wOF2"), "font/woff2"},
+}
+
+var htmlPrefixes = [][]byte{
+	[]byte("<!doctype html"),
+	[]byte("<html"),
+	[]byte("<head"),
+	[]byte("<body"),
+	[]byte("<!--"),
+}
+
+// detectContentType is a small subset of the WHATWG MIME sniffing algorithm,
+// used when a file's extension does not identify its type.
+func detectContentType(data []byte) string {
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, sig.prefix) {
+			return sig.contentType
+		}
+	}
+
+	trimmed := bytes.ToLower(bytes.TrimLeft(data, " \t\r\n"))
+	for _, prefix := range htmlPrefixes {
+		if bytes.HasPrefix(trimmed, prefix) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if bytes.HasPrefix(trimmed, []byte("<?xml")) {
+		return "text/xml; charset=utf-8"
+	}
+
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isText reports whether data looks like UTF-8 text without control bytes.
+// A multi-byte sequence cut off by the sniff window is tolerated.
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			if len(data) < utf8.UTFMax && !utf8.FullRune(data) {
+				return true
+			}
+			return false
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
@@ -20,6 +20,40 @@ func (h Headers) Set(key, value string) {
 	h[strings.ToLower(key)] = value
 }
 
+// Add appends value to any existing value for key as a comma-separated list.
+func (h Headers) Add(key, value string) {
+	key = strings.ToLower(key)
+	if existing, ok := h[key]; ok && existing != "" {
+		h[key] = existing + ", " + value
+	} else {
+		h[key] = value
+	}
+}
+
+// AddVary lists field in the Vary header unless it is already covered.
+func (h Headers) AddVary(field string) {
+	for _, existing := range strings.Split(h.Get("Vary"), ",") {
+		existing = strings.TrimSpace(existing)
+		if existing == "*" || strings.EqualFold(existing, field) {
+			return
+		}
+	}
+	h.Add("Vary", field)
+}
+
+func (h Headers) Del(key string) {
+	delete(h, strings.ToLower(key))
+}
+
+// Clone returns a copy of h that can be modified independently.
+func (h Headers) Clone() Headers {
+	clone := make(Headers, len(h))
+	for key, value := range h {
+		clone[key] = value
+	}
+	return clone
+}
+
 // Parse parses the provided data and returns the number of bytes consumed,
 // whether the parsing is done, and any error encountered.
 // Parse is done when it encounters a blank line.
@@ -58,7 +92,13 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	lowercaseKey := string(bytes.ToLower(key))
 	value := bytes.TrimSpace(headerLine[colonIndex+1:])
 	if existingVal, ok := h[lowercaseKey]; ok {
-		h[lowercaseKey] = existingVal + "," + string(value)
+		// Cookie pairs are separated by semicolons, not commas, so
+		// repeated Cookie lines must be joined the same way.
+		sep := ","
+		if lowercaseKey == "cookie" {
+			sep = "; "
+		}
+		h[lowercaseKey] = existingVal + sep + string(value)
 	} else {
 		h[lowercaseKey] = string(value)
 	}
@@ -84,6 +124,39 @@ func isTokenChar(b byte) bool {
 	return false
 }
 
+// IsToken reports whether s is a non-empty token (RFC 9110 5.6.2).
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// SplitQuoted splits s at each sep that is not inside a quoted string
+// (RFC 9110 5.6.4), so that list members and parameters can be taken apart
+// without breaking quoted values.
+func SplitQuoted(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
 func validateHeaderKey(key []byte) bool {
 	if len(key) == 0 {
 		return false
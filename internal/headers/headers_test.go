@@ -108,4 +108,40 @@ func TestParseMultipleHeaders(t *testing.T) {
 		assert.True(t, done3)
 		assert.Equal(t, 2, n3)
 	})
+
+	t.Run("Repeated cookie lines", func(t *testing.T) {
+		headers := NewHeaders()
+		data := []byte("Cookie: a=1\r\nCookie: b=2\r\n")
+		n, _, err := headers.Parse(data)
+		require.NoError(t, err)
+		_, _, err = headers.Parse(data[n:])
+		require.NoError(t, err)
+		assert.Equal(t, "a=1; b=2", headers.Get("Cookie"))
+	})
+}
+
+func TestAddVary(t *testing.T) {
+	h := NewHeaders()
+	h.AddVary("Origin")
+	h.AddVary("Accept-Encoding")
+	h.AddVary("origin")
+	assert.Equal(t, "Origin, Accept-Encoding", h.Get("Vary"))
+
+	h.Set("Vary", "*")
+	h.AddVary("Origin")
+	assert.Equal(t, "*", h.Get("Vary"))
+}
+
+func TestIsToken(t *testing.T) {
+	assert.True(t, IsToken("gzip"))
+	assert.True(t, IsToken("x-custom_1.0!"))
+	assert.False(t, IsToken(""))
+	assert.False(t, IsToken("a b"))
+	assert.False(t, IsToken("a/b"))
+	assert.False(t, IsToken("caf\xc3\xa9"))
+}
+
+func TestSplitQuoted(t *testing.T) {
+	assert.Equal(t, []string{"a", ` b="x,y"`, ` c="q\",r"`, ""}, SplitQuoted(`a, b="x,y", c="q\",r",`, ','))
+	assert.Equal(t, []string{"only"}, SplitQuoted("only", ';'))
 }
@@ -0,0 +1,124 @@
+package jsonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of JSON responses.
+const ContentType = "application/json"
+
+// DefaultMaxBytes bounds the body Decode accepts when maxBytes is zero.
+const DefaultMaxBytes = 1 << 20
+
+// Decode errors wrap one of these.
+var (
+	// ErrUnsupportedMediaType means the body is not declared as JSON.
+	ErrUnsupportedMediaType = errors.New("request body is not JSON")
+	// ErrInvalidJSON means the body is not valid JSON, has fields the
+	// target does not, holds values of the wrong type or has data after
+	// the value.
+	ErrInvalidJSON = errors.New("invalid JSON")
+	ErrTooLarge    = errors.New("request body too large")
+)
+
+// Decode unmarshals the request body into v. The Content-Type must be
+// application/json or another "+json" type, with no charset but UTF-8. The
+// body must hold exactly one JSON value of at most maxBytes
+// (DefaultMaxBytes if zero), without fields v does not have.
+func Decode(req *request.Request, v any, maxBytes int) error {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if err := checkContentType(req.Headers.Get("Content-Type")); err != nil {
+		return err
+	}
+	if len(req.Body) > maxBytes {
+		return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxBytes)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(req.Body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: empty body", ErrInvalidJSON)
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: unexpected data after the JSON value", ErrInvalidJSON)
+	}
+	return nil
+}
+
+func checkContentType(contentType string) error {
+	if contentType == "" {
+		return fmt.Errorf("%w: no Content-Type", ErrUnsupportedMediaType)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnsupportedMediaType, err)
+	}
+	if mediaType != ContentType && !strings.HasSuffix(mediaType, "+json") {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return fmt.Errorf("%w: charset %s", ErrUnsupportedMediaType, charset)
+	}
+	return nil
+}
+
+// Bind decodes the request body into v like Decode. If that fails it
+// answers with a problem response: 415 for a body that is not declared as
+// JSON, 413 for one that is too large and 400 otherwise. It reports whether
+// the handler should go on.
+func Bind(w *response.Writer, req *request.Request, v any, maxBytes int) bool {
+	err := Decode(req, v, maxBytes)
+	if err == nil {
+		return true
+	}
+	status := response.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrUnsupportedMediaType):
+		status = response.StatusUnsupportedMediaType
+	case errors.Is(err, ErrTooLarge):
+		status = response.StatusContentTooLarge
+	}
+	request.LoggerFromContext(req.Context()).Debug("cannot decode JSON body", "category", "json", "error", err)
+	WriteError(w, status, err.Error())
+	return false
+}
+
+// Write sends v as a JSON response with the given status.
+func Write(w *response.Writer, statusCode response.StatusCode, v any) error {
+	return write(w, statusCode, ContentType, v, nil)
+}
+
+func write(w *response.Writer, statusCode response.StatusCode, contentType string, v any, h headers.Headers) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	h = h.Clone()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
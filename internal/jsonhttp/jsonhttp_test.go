@@ -0,0 +1,175 @@
+package jsonhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func newRequest(t *testing.T, contentType, body string) *request.Request {
+	t.Helper()
+	raw := "POST /items HTTP/1.1\r\nHost: localhost\r\n"
+	if contentType != "" {
+		raw += "Content-Type: " + contentType + "\r\n"
+	}
+	raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+// splitResponse returns the head, each line ending in CRLF, and the body of
+// a raw response.
+func splitResponse(t *testing.T, raw string) (string, string) {
+	t.Helper()
+	head, body, ok := strings.Cut(raw, "\r\n\r\n")
+	require.True(t, ok)
+	return head + "\r\n", body
+}
+
+func TestDecode(t *testing.T) {
+	var v item
+	require.NoError(t, Decode(newRequest(t, "application/json", `{"name":"a","count":2}`), &v, 0))
+	assert.Equal(t, item{"a", 2}, v)
+	require.NoError(t, Decode(newRequest(t, "application/merge-patch+json; charset=UTF-8", `{"count":3}`), &v, 0))
+	assert.Equal(t, item{"a", 3}, v)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		maxBytes    int
+		err         error
+	}{
+		{"no content type", "", `{}`, 0, ErrUnsupportedMediaType},
+		{"not json", "text/plain", `{}`, 0, ErrUnsupportedMediaType},
+		{"other charset", "application/json; charset=latin1", `{}`, 0, ErrUnsupportedMediaType},
+		{"empty", "application/json", ``, 0, ErrInvalidJSON},
+		{"syntax", "application/json", `{"name":`, 0, ErrInvalidJSON},
+		{"unknown field", "application/json", `{"name":"a","extra":1}`, 0, ErrInvalidJSON},
+		{"wrong type", "application/json", `{"count":"many"}`, 0, ErrInvalidJSON},
+		{"trailing value", "application/json", `{} {}`, 0, ErrInvalidJSON},
+		{"trailing garbage", "application/json", `{}x`, 0, ErrInvalidJSON},
+		{"too large", "application/json", `{"name":"abcdef"}`, 10, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v item
+			err := Decode(newRequest(t, tt.contentType, tt.body), &v, tt.maxBytes)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	// Trailing whitespace is fine.
+	require.NoError(t, Decode(newRequest(t, "application/json", "{}\r\n"), &v, 0))
+}
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      response.StatusCode
+	}{
+		{"unsupported", "text/plain", `{}`, response.StatusUnsupportedMediaType},
+		{"invalid", "application/json", `{"extra":1}`, response.StatusBadRequest},
+		{"too large", "application/json", `{"name":"abcdefghijkl"}`, response.StatusContentTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := response.NewWriter(&buf)
+			var v item
+			assert.False(t, Bind(w, newRequest(t, tt.contentType, tt.body), &v, 16))
+			assert.Equal(t, tt.status, w.StatusCode())
+
+			head, body := splitResponse(t, buf.String())
+			assert.Contains(t, head, "content-type: application/problem+json\r\n")
+			var problem map[string]any
+			require.NoError(t, json.Unmarshal([]byte(body), &problem))
+			assert.Equal(t, float64(tt.status), problem["status"])
+			assert.Equal(t, response.StatusText(tt.status), problem["title"])
+			assert.NotEmpty(t, problem["detail"])
+			assert.NotContains(t, problem, "type")
+		})
+	}
+
+	var buf bytes.Buffer
+	var v item
+	assert.True(t, Bind(response.NewWriter(&buf), newRequest(t, "application/json", `{"name":"a"}`), &v, 0))
+	assert.Zero(t, buf.Len())
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, Write(w, response.StatusOK, item{"a", 1}))
+
+	head, body := splitResponse(t, buf.String())
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, head, "content-type: application/json\r\n")
+	assert.Contains(t, head, "content-length: "+strconv.Itoa(len(body))+"\r\n")
+	assert.Equal(t, "{\"name\":\"a\",\"count\":1}\n", body)
+
+	// Nothing is written for a value that cannot be marshaled.
+	buf.Reset()
+	assert.Error(t, Write(response.NewWriter(&buf), response.StatusOK, func() {}))
+	assert.Zero(t, buf.Len())
+}
+
+func TestProblem(t *testing.T) {
+	p := &Problem{
+		Type:     "https://example.com/probs/out-of-credit",
+		Title:    "You do not have enough credit.",
+		Status:   response.StatusForbidden,
+		Detail:   "Your current balance is 30, but that costs 50.",
+		Instance: "/account/12345/msgs/abc",
+		Extensions: map[string]any{
+			"balance": 30,
+			"status":  "ignored",
+			"title":   "ignored",
+		},
+	}
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "https://example.com/probs/out-of-credit",
+		"title": "You do not have enough credit.",
+		"status": 403,
+		"detail": "Your current balance is 30, but that costs 50.",
+		"instance": "/account/12345/msgs/abc",
+		"balance": 30
+	}`, string(data))
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteProblem(response.NewWriter(&buf), &Problem{Detail: "boom"}))
+	head, body := splitResponse(t, buf.String())
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.JSONEq(t, `{"title":"Internal Server Error","status":500,"detail":"boom"}`, body)
+}
+
+func TestErrorHandler(t *testing.T) {
+	var buf bytes.Buffer
+	ErrorHandler(response.NewWriter(&buf), response.StatusBadRequest, errors.New("malformed request line"))
+	head, body := splitResponse(t, buf.String())
+	assert.Contains(t, head, "connection: close\r\n")
+	assert.JSONEq(t, `{"title":"Bad Request","status":400,"detail":"malformed request line"}`, body)
+
+	buf.Reset()
+	ErrorHandler(response.NewWriter(&buf), response.StatusInternalServerError, errors.New("secret"))
+	_, body = splitResponse(t, buf.String())
+	assert.JSONEq(t, `{"title":"Internal Server Error","status":500}`, body)
+}
@@ -0,0 +1,88 @@
+package jsonhttp
+
+import (
+	"encoding/json"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/response"
+	"maps"
+)
+
+// ProblemContentType is the media type of problem details (RFC 9457).
+const ProblemContentType = "application/problem+json"
+
+// Problem describes an error in the format of RFC 9457.
+type Problem struct {
+	// Type is a URI identifying the kind of problem; "about:blank" if
+	// empty.
+	Type string
+	// Title is a short summary of the kind of problem; for about:blank
+	// the reason phrase of Status.
+	Title  string
+	Status response.StatusCode
+	// Detail explains this occurrence of the problem.
+	Detail string
+	// Instance is a URI identifying this occurrence.
+	Instance string
+	// Extensions are additional members. They cannot replace the members
+	// above.
+	Extensions map[string]any
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := maps.Clone(p.Extensions)
+	if members == nil {
+		members = make(map[string]any)
+	}
+	set := func(name, value string) {
+		if value != "" {
+			members[name] = value
+		} else {
+			delete(members, name)
+		}
+	}
+	set("type", p.Type)
+	set("title", p.Title)
+	set("detail", p.Detail)
+	set("instance", p.Instance)
+	delete(members, "status")
+	if p.Status != 0 {
+		members["status"] = int(p.Status)
+	}
+	return json.Marshal(members)
+}
+
+// WriteProblem sends p as an application/problem+json response with status
+// p.Status, 500 if unset. An untyped problem gets the status's reason
+// phrase as its title.
+func WriteProblem(w *response.Writer, p *Problem) error {
+	return writeProblem(w, p, nil)
+}
+
+func writeProblem(w *response.Writer, p *Problem, h headers.Headers) error {
+	problem := *p
+	if problem.Status == 0 {
+		problem.Status = response.StatusInternalServerError
+	}
+	if problem.Type == "" && problem.Title == "" {
+		problem.Title = response.StatusText(problem.Status)
+	}
+	return write(w, problem.Status, ProblemContentType, &problem, h)
+}
+
+// WriteError sends a problem response with the given status and detail.
+func WriteError(w *response.Writer, statusCode response.StatusCode, detail string) error {
+	return WriteProblem(w, &Problem{Status: statusCode, Detail: detail})
+}
+
+// ErrorHandler answers requests the server cannot handle with problem
+// responses, for use with server.WithErrorHandler. Like the default handler
+// it only describes client errors.
+func ErrorHandler(w *response.Writer, statusCode response.StatusCode, err error) {
+	var detail string
+	if statusCode < 500 {
+		detail = err.Error()
+	}
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	writeProblem(w, &Problem{Status: statusCode, Detail: detail}, h)
+}
@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are histogram upper bounds suited to request latencies in
+// seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.v.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v atomic.Int64
+}
+
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+func (g *Gauge) Add(n int64) {
+	g.v.Add(n)
+}
+
+func (g *Gauge) Set(n int64) {
+	g.v.Store(n)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) {
+	i, _ := slices.BinarySearch(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+type histogramSnapshot struct {
+	cumulative []uint64
+	count      uint64
+	sum        float64
+}
+
+func (h *Histogram) snapshot() histogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := histogramSnapshot{cumulative: make([]uint64, len(h.counts)), count: h.count, sum: h.sum}
+	var total uint64
+	for i, n := range h.counts {
+		total += n
+		s.cumulative[i] = total
+	}
+	return s
+}
+
+// vec holds one child metric per combination of label values.
+type vec[T any] struct {
+	labels  []string
+	newItem func() *T
+
+	mu       sync.RWMutex
+	children map[string]*child[T]
+}
+
+type child[T any] struct {
+	values []string
+	item   *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic("metrics: wrong number of label values")
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.item
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.item
+	}
+	c = &child[T]{values: slices.Clone(values), item: v.newItem()}
+	v.children[key] = c
+	return c.item
+}
+
+// each calls fn for every child, ordered by label values so the output is
+// stable.
+func (v *vec[T]) each(fn func(values []string, item *T)) {
+	v.mu.RLock()
+	children := make([]*child[T], 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.RUnlock()
+
+	slices.SortFunc(children, func(a, b *child[T]) int {
+		return slices.Compare(a.values, b.values)
+	})
+	for _, c := range children {
+		fn(c.values, c.item)
+	}
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct {
+	vec[Counter]
+}
+
+// With returns the counter for the given label values, in the order the
+// labels were declared, creating it on first use.
+func (cv *CounterVec) With(values ...string) *Counter {
+	return cv.with(values)
+}
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct {
+	vec[Histogram]
+}
+
+// With returns the histogram for the given label values, in the order the
+// labels were declared, creating it on first use.
+func (hv *HistogramVec) With(values ...string) *Histogram {
+	return hv.with(values)
+}
+
+func checkBuckets(buckets []float64) []float64 {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	if len(buckets) > 0 && math.IsInf(buckets[len(buckets)-1], 1) {
+		buckets = buckets[:len(buckets)-1]
+	}
+	if !slices.IsSorted(buckets) {
+		panic("metrics: histogram buckets must be sorted")
+	}
+	return buckets
+}
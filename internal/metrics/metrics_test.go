@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewGauge("active", "Currently active.").Set(3)
+	requests := reg.NewCounterVec("requests_total", "Requests by code.", "method", "code")
+	requests.With("GET", "2xx").Add(2)
+	requests.With("GET", "4xx").Inc()
+	requests.With("POST", "2xx").Inc()
+	latency := reg.NewHistogram("latency_seconds", "Latency.\nSecond line.", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.1)
+	latency.Observe(5)
+
+	var buf bytes.Buffer
+	n, err := reg.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.EqualValues(t, buf.Len(), n)
+	assert.Equal(t, `# HELP active Currently active.
+# TYPE active gauge
+active 3
+# HELP latency_seconds Latency.\nSecond line.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.15
+latency_seconds_count 3
+# HELP requests_total Requests by code.
+# TYPE requests_total counter
+requests_total{method="GET",code="2xx"} 2
+requests_total{method="GET",code="4xx"} 1
+requests_total{method="POST",code="2xx"} 1
+`, buf.String())
+}
+
+func TestLabelValuesEscaped(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounterVec("c", "", "path").With("a\"b\\c\nd").Inc()
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+	assert.Contains(t, buf.String(), `c{path="a\"b\\c\nd"} 1`)
+}
+
+func TestHistogramVec(t *testing.T) {
+	reg := NewRegistry()
+	hv := reg.NewHistogramVec("d", "", nil, "method")
+	hv.With("GET").Observe(0.003)
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+	out := buf.String()
+	assert.Contains(t, out, `d_bucket{method="GET",le="0.005"} 1`)
+	assert.Contains(t, out, `d_bucket{method="GET",le="+Inf"} 1`)
+	assert.Contains(t, out, `d_count{method="GET"} 1`)
+}
+
+func TestRegisterPanics(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("dup", "")
+	assert.Panics(t, func() { reg.NewGauge("dup", "") })
+	assert.Panics(t, func() { reg.NewCounter("bad-name", "") })
+	assert.Panics(t, func() { reg.NewCounterVec("v", "", "le") })
+	assert.Panics(t, func() { reg.NewHistogram("h", "", []float64{1, 0.5}) })
+	assert.Panics(t, func() { reg.NewCounterVec("w", "", "a").With("x", "y") })
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("hits_total", "Hits.").Inc()
+
+	for _, tc := range []struct {
+		method string
+		status string
+		body   bool
+	}{
+		{"GET", "HTTP/1.1 200 OK", true},
+		{"HEAD", "HTTP/1.1 200 OK", false},
+		{"POST", "HTTP/1.1 405 Method Not Allowed", false},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			req, err := request.RequestFromReader(strings.NewReader(tc.method + " /metrics HTTP/1.1\r\nHost: x\r\n\r\n"))
+			require.NoError(t, err)
+			var out bytes.Buffer
+			Handler(reg)(response.NewWriter(&out), req)
+
+			head, body, _ := strings.Cut(out.String(), "\r\n\r\n")
+			assert.True(t, strings.HasPrefix(head, tc.status), head)
+			if tc.method != "POST" {
+				assert.Contains(t, head, "content-type: "+ContentType)
+			}
+			assert.Equal(t, tc.body, strings.Contains(body, "hits_total 1"))
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var validName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Registry holds metric families and renders them in the Prometheus text
+// exposition format. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+type family struct {
+	name  string
+	help  string
+	kind  string
+	write func(w *bufio.Writer, name string)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// NewCounter registers and returns a counter. Like the other constructors
+// it panics if name is invalid or already registered, since that is a
+// programming error.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", nil, func(w *bufio.Writer, name string) {
+		writeSample(w, name, "", strconv.FormatUint(c.Value(), 10))
+	})
+	return c
+}
+
+// NewCounterVec registers a counter family with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{vec[Counter]{
+		labels:   labels,
+		newItem:  func() *Counter { return &Counter{} },
+		children: make(map[string]*child[Counter]),
+	}}
+	r.register(name, help, "counter", labels, func(w *bufio.Writer, name string) {
+		cv.each(func(values []string, c *Counter) {
+			writeSample(w, name, labelPairs(labels, values), strconv.FormatUint(c.Value(), 10))
+		})
+	})
+	return cv
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", nil, func(w *bufio.Writer, name string) {
+		writeSample(w, name, "", strconv.FormatInt(g.Value(), 10))
+	})
+	return g
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// or DefaultBuckets if none are given.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	buckets = checkBuckets(buckets)
+	h := newHistogram(buckets)
+	r.register(name, help, "histogram", nil, func(w *bufio.Writer, name string) {
+		writeHistogram(w, name, "", buckets, h.snapshot())
+	})
+	return h
+}
+
+// NewHistogramVec registers a histogram family with the given label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = checkBuckets(buckets)
+	hv := &HistogramVec{vec[Histogram]{
+		labels:   labels,
+		newItem:  func() *Histogram { return newHistogram(buckets) },
+		children: make(map[string]*child[Histogram]),
+	}}
+	r.register(name, help, "histogram", labels, func(w *bufio.Writer, name string) {
+		hv.each(func(values []string, h *Histogram) {
+			writeHistogram(w, name, labelPairs(labels, values), buckets, h.snapshot())
+		})
+	})
+	return hv
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, write func(*bufio.Writer, string)) {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !validName.MatchString(label) || strings.Contains(label, ":") || label == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q", label))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metrics: %q is already registered", name))
+	}
+	r.families[name] = family{name: name, help: help, kind: kind, write: write}
+}
+
+// WriteTo writes every registered metric, sorted by name, in the text
+// exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(families, func(a, b family) int { return strings.Compare(a.name, b.name) })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.kind)
+		f.write(bw, f.name)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry's metrics, for mounting at e.g. /metrics.
+func Handler(r *Registry) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+			h := headers.NewHeaders()
+			h.Set("Allow", "GET, HEAD")
+			w.WriteError(response.StatusMethodNotAllowed, h)
+			return
+		}
+
+		var body strings.Builder
+		r.WriteTo(&body)
+
+		h := headers.NewHeaders()
+		h.Set("Content-Type", ContentType)
+		h.Set("Content-Length", strconv.Itoa(body.Len()))
+		h.Set("Cache-Control", "no-store")
+		if err := w.WriteStatusLine(response.StatusOK); err != nil {
+			return
+		}
+		if err := w.WriteHeaders(h); err != nil || req.RequestLine.Method == "HEAD" {
+			return
+		}
+		w.WriteBody([]byte(body.String()))
+	}
+}
+
+func writeHistogram(w *bufio.Writer, name, labels string, buckets []float64, s histogramSnapshot) {
+	for i, upper := range buckets {
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(upper)+`"`), strconv.FormatUint(s.cumulative[i], 10))
+	}
+	writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), strconv.FormatUint(s.count, 10))
+	writeSample(w, name+"_sum", labels, formatFloat(s.sum))
+	writeSample(w, name+"_count", labels, strconv.FormatUint(s.count, 10))
+}
+
+func writeSample(w *bufio.Writer, name, labels, value string) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + value + "\n")
+}
+
+func labelPairs(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabelValue(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
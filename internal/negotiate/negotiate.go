@@ -0,0 +1,214 @@
+package negotiate
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// MediaType picks the offered media type the Accept value weights highest,
+// preferring earlier offers on a tie. Each offer is weighted by the most
+// specific range that matches it, so "text/html;q=0" excludes HTML even
+// with "*/*" present. Offers may carry parameters, which ranges with
+// parameters must match. Without an Accept value the first offer is
+// chosen; MediaType returns "" if the client accepts none of them.
+func MediaType(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return first(offers)
+	}
+	ranges := ParseAccept(accept)
+	return best(offers, func(offer string) (float64, int) {
+		mediaType, params, err := mime.ParseMediaType(offer)
+		if err != nil {
+			return 0, -1
+		}
+		typ, _, _ := strings.Cut(mediaType, "/")
+
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.Value == "*/*":
+				s = 0
+			case r.Value == typ+"/*":
+				s = 1
+			case r.Value == mediaType && paramsMatch(r.Params, params):
+				s = 2 + len(r.Params)
+			}
+			if s > specificity {
+				q, specificity = r.Q, s
+			}
+		}
+		return q, specificity
+	})
+}
+
+func paramsMatch(want, have map[string]string) bool {
+	for name, value := range want {
+		if !strings.EqualFold(have[name], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Language picks the offered language tag the Accept-Language value
+// weights highest, preferring earlier offers on a tie. A range matches a
+// tag equal to it or starting with it followed by "-" (RFC 4647 basic
+// filtering), so "en" matches "en-GB" but "en-GB" does not match "en"; the
+// longest matching range gives the weight. Without an Accept-Language value
+// the first offer is chosen; Language returns "" if none is acceptable.
+func Language(acceptLanguage string, offers ...string) string {
+	if strings.TrimSpace(acceptLanguage) == "" {
+		return first(offers)
+	}
+	ranges := ParseList(acceptLanguage)
+	return best(offers, func(offer string) (float64, int) {
+		tag := strings.ToLower(offer)
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.Value == "*":
+				s = 0
+			case tag == r.Value || strings.HasPrefix(tag, r.Value+"-"):
+				s = len(r.Value)
+			}
+			if s > specificity {
+				q, specificity = r.Q, s
+			}
+		}
+		return q, specificity
+	})
+}
+
+// Charset picks the offered charset the Accept-Charset value weights
+// highest, preferring earlier offers on a tie. Without an Accept-Charset
+// value the first offer is chosen; Charset returns "" if none is
+// acceptable.
+func Charset(acceptCharset string, offers ...string) string {
+	if strings.TrimSpace(acceptCharset) == "" {
+		return first(offers)
+	}
+	ranges := ParseList(acceptCharset)
+	return best(offers, func(offer string) (float64, int) {
+		charset := strings.ToLower(offer)
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch r.Value {
+			case "*":
+				s = 0
+			case charset:
+				s = 1
+			}
+			if s > specificity {
+				q, specificity = r.Q, s
+			}
+		}
+		return q, specificity
+	})
+}
+
+// best returns the first offer with the highest positive weight.
+func best(offers []string, weigh func(offer string) (q float64, specificity int)) string {
+	chosen, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q, specificity := weigh(offer); specificity >= 0 && q > bestQ {
+			chosen, bestQ = offer, q
+		}
+	}
+	return chosen
+}
+
+func first(offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	return offers[0]
+}
+
+// Offers lists the representations a handler can produce. An empty list
+// leaves that dimension out of the negotiation.
+type Offers struct {
+	MediaTypes []string
+	Languages  []string
+	Charsets   []string
+}
+
+// Choice is the outcome of Negotiate; fields for dimensions without offers
+// are empty.
+type Choice struct {
+	MediaType string
+	Language  string
+	Charset   string
+}
+
+// Negotiate picks a representation for req from offers with MediaType,
+// Language and Charset. It adds the request headers it looked at to the
+// response's Vary header, whatever the handler then writes. If some
+// dimension has no acceptable offer it answers 406 Not Acceptable, listing
+// what is available, and reports false.
+func Negotiate(w *response.Writer, req *request.Request, offers Offers) (Choice, bool) {
+	var choice Choice
+	var vary []string
+	acceptable := true
+	if len(offers.MediaTypes) > 0 {
+		choice.MediaType = MediaType(req.Headers.Get("Accept"), offers.MediaTypes...)
+		acceptable = acceptable && choice.MediaType != ""
+		vary = append(vary, "Accept")
+	}
+	if len(offers.Languages) > 0 {
+		choice.Language = Language(req.Headers.Get("Accept-Language"), offers.Languages...)
+		acceptable = acceptable && choice.Language != ""
+		vary = append(vary, "Accept-Language")
+	}
+	if len(offers.Charsets) > 0 {
+		choice.Charset = Charset(req.Headers.Get("Accept-Charset"), offers.Charsets...)
+		acceptable = acceptable && choice.Charset != ""
+		vary = append(vary, "Accept-Charset")
+	}
+
+	w.OnWriteHeaders(func(_ response.StatusCode, h headers.Headers) {
+		for _, field := range vary {
+			h.AddVary(field)
+		}
+	})
+	if !acceptable {
+		request.LoggerFromContext(req.Context()).Debug("no acceptable representation", "category", "negotiate",
+			"accept", req.Headers.Get("Accept"), "accept_language", req.Headers.Get("Accept-Language"),
+			"accept_charset", req.Headers.Get("Accept-Charset"))
+		writeNotAcceptable(w, offers)
+		return Choice{}, false
+	}
+	return choice, true
+}
+
+func writeNotAcceptable(w *response.Writer, offers Offers) {
+	var b strings.Builder
+	b.WriteString("406 Not Acceptable\n")
+	for _, available := range []struct {
+		name   string
+		offers []string
+	}{
+		{"Media types", offers.MediaTypes},
+		{"Languages", offers.Languages},
+		{"Charsets", offers.Charsets},
+	} {
+		if len(available.offers) > 0 {
+			b.WriteString(available.name + ": " + strings.Join(available.offers, ", ") + "\n")
+		}
+	}
+	body := b.String()
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if w.WriteStatusLine(response.StatusNotAcceptable) != nil || w.WriteHeaders(h) != nil {
+		return
+	}
+	w.WriteBody([]byte(body))
+}
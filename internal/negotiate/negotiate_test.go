@@ -0,0 +1,128 @@
+package negotiate
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccept(t *testing.T) {
+	ranges := ParseAccept(`text/html;level=1, Text/*;q=0.3, application/json;q=0.9;ext=1, */*;q=0.1, ` +
+		`text/plain;format="a,b;c\"d", */html, text/x;q=2, bad, text/y;=x`)
+	assert.Equal(t, []Range{
+		{Value: "text/html", Params: map[string]string{"level": "1"}, Q: 1},
+		{Value: "text/*", Q: 0.3},
+		{Value: "application/json", Q: 0.9},
+		{Value: "*/*", Q: 0.1},
+		{Value: "text/plain", Params: map[string]string{"format": `a,b;c"d`}, Q: 1},
+	}, ranges)
+	assert.Empty(t, ParseAccept(""))
+}
+
+func TestParseList(t *testing.T) {
+	assert.Equal(t, []Range{
+		{Value: "da", Q: 1},
+		{Value: "en-gb", Q: 0.8},
+		{Value: "en", Q: 0.7},
+		{Value: "*", Q: 0},
+	}, ParseList("da, en-GB;q=0.8, en;Q=0.7, fr;q=1.5, *;q=0, , b@d"))
+}
+
+func TestMediaType(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		offers []string
+		want   string
+	}{
+		{"no header", "", []string{"text/html", "application/json"}, "text/html"},
+		{"exact", "application/json", []string{"text/html", "application/json"}, "application/json"},
+		{"weights", "text/html;q=0.5, application/json", []string{"text/html", "application/json"}, "application/json"},
+		{"tie prefers earlier offer", "*/*", []string{"application/json", "text/html"}, "application/json"},
+		{"type wildcard", "text/*", []string{"application/json", "text/plain"}, "text/plain"},
+		{"specific exclusion beats wildcard", "text/html;q=0, */*", []string{"text/html", "text/plain"}, "text/plain"},
+		{"specific weight beats wildcard", "text/*;q=0.9, text/html;q=0.1, application/json;q=0.5",
+			[]string{"text/html", "application/json"}, "application/json"},
+		{"params must match", "text/html;level=2, */*;q=0.1",
+			[]string{"text/html;level=1", "text/plain"}, "text/html;level=1"},
+		{"params weigh", "text/html;level=1, text/html;q=0.2, text/plain;q=0.5",
+			[]string{"text/html; level=1", "text/plain"}, "text/html; level=1"},
+		{"case insensitive", "APPLICATION/JSON", []string{"application/json"}, "application/json"},
+		{"none acceptable", "image/png", []string{"text/html", "application/json"}, ""},
+		{"all excluded", "*/*;q=0", []string{"text/html"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, MediaType(tt.accept, tt.offers...))
+		})
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		offers         []string
+		want           string
+	}{
+		{"", []string{"en", "fr"}, "en"},
+		{"fr, en;q=0.5", []string{"en", "fr"}, "fr"},
+		{"en", []string{"fr", "en-GB"}, "en-GB"},
+		{"en-GB", []string{"en"}, ""},
+		{"en-gb, en;q=0.5", []string{"en-US", "en-GB"}, "en-GB"},
+		{"en, en-us;q=0", []string{"en-US", "en-GB"}, "en-GB"},
+		{"enx", []string{"en"}, ""},
+		{"de, *;q=0.1", []string{"fr", "de"}, "de"},
+		{"*;q=0.1", []string{"fr"}, "fr"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Language(tt.acceptLanguage, tt.offers...), tt.acceptLanguage)
+	}
+}
+
+func TestCharset(t *testing.T) {
+	assert.Equal(t, "utf-8", Charset("", "utf-8", "iso-8859-1"))
+	assert.Equal(t, "ISO-8859-1", Charset("iso-8859-1, utf-8;q=0.5", "utf-8", "ISO-8859-1"))
+	assert.Equal(t, "iso-8859-1", Charset("utf-8;q=0, *", "utf-8", "iso-8859-1"))
+	assert.Equal(t, "", Charset("utf-16", "utf-8"))
+}
+
+func run(t *testing.T, header string, offers Offers) (Choice, bool, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n" + header + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	choice, ok := Negotiate(w, req, offers)
+	if ok {
+		require.NoError(t, w.WriteError(response.StatusOK, nil))
+	}
+	return choice, ok, buf.String()
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := Offers{MediaTypes: []string{"text/html", "application/json"}, Languages: []string{"en", "fr"}}
+
+	choice, ok, raw := run(t, "Accept: application/json\r\nAccept-Language: fr\r\n", offers)
+	require.True(t, ok)
+	assert.Equal(t, Choice{MediaType: "application/json", Language: "fr"}, choice)
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, raw, "vary: Accept, Accept-Language\r\n")
+
+	_, ok, raw = run(t, "Accept: application/json\r\nAccept-Language: de\r\n", offers)
+	assert.False(t, ok)
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 406 Not Acceptable\r\n"))
+	assert.Contains(t, raw, "vary: Accept, Accept-Language\r\n")
+	assert.Contains(t, raw, "Media types: text/html, application/json\n")
+	assert.Contains(t, raw, "Languages: en, fr\n")
+	assert.NotContains(t, raw, "Charsets")
+
+	choice, ok, _ = run(t, "", Offers{Charsets: []string{"utf-8"}})
+	require.True(t, ok)
+	assert.Equal(t, Choice{Charset: "utf-8"}, choice)
+}
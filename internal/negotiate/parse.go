@@ -0,0 +1,127 @@
+package negotiate
+
+import (
+	"httpfromtcp/internal/headers"
+	"strconv"
+	"strings"
+)
+
+// Range is one member of an Accept-style header: a media range, language
+// range, charset or coding, with its weight.
+type Range struct {
+	// Value is the lower-cased range, such as "text/*", "en-gb" or "*".
+	Value string
+	// Params holds the media-type parameters of an Accept member, with
+	// lower-cased names. Extension parameters after the weight are dropped.
+	Params map[string]string
+	// Q is the weight, 1 unless the member gave one.
+	Q float64
+}
+
+// ParseAccept parses an Accept value (RFC 9110 12.5.1) into its media
+// ranges, in the order given. Members that are malformed, such as
+// "*/html" or ones with an invalid weight, are skipped.
+func ParseAccept(value string) []Range {
+	var ranges []Range
+	for _, member := range headers.SplitQuoted(value, ',') {
+		params := headers.SplitQuoted(member, ';')
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+		typ, subtype, ok := strings.Cut(mediaRange, "/")
+		if !ok || !headers.IsToken(typ) || !headers.IsToken(subtype) || (typ == "*" && subtype != "*") {
+			continue
+		}
+
+		r := Range{Value: mediaRange, Q: 1}
+		valid := true
+		for _, param := range params[1:] {
+			name, val, found := strings.Cut(strings.TrimSpace(param), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !found || !headers.IsToken(name) {
+				valid = false
+				break
+			}
+			val = strings.TrimSpace(val)
+			if name == "q" {
+				r.Q, valid = parseQValue(val)
+				// Anything after the weight is an accept-ext.
+				break
+			}
+			if val, found = unquote(val); !found {
+				valid = false
+				break
+			}
+			if r.Params == nil {
+				r.Params = make(map[string]string)
+			}
+			r.Params[name] = val
+		}
+		if valid {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// ParseList parses a list of weighted tokens, as in Accept-Language
+// (RFC 9110 12.5.4), Accept-Charset (12.5.2) or Accept-Encoding (12.5.3),
+// in the order given, with lower-cased values. Members that are not tokens
+// or have an invalid weight are skipped.
+func ParseList(value string) []Range {
+	var ranges []Range
+	for _, member := range strings.Split(value, ",") {
+		token, params, _ := strings.Cut(member, ";")
+		token = strings.ToLower(strings.TrimSpace(token))
+		if !headers.IsToken(token) {
+			continue
+		}
+
+		q, ok := 1.0, true
+		for _, param := range strings.Split(params, ";") {
+			name, val, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			q, ok = parseQValue(strings.TrimSpace(val))
+		}
+		if ok {
+			ranges = append(ranges, Range{Value: token, Q: q})
+		}
+	}
+	return ranges
+}
+
+// parseQValue parses a weight, which must be between 0 and 1 with at most
+// three decimals (RFC 9110 12.4.2).
+func parseQValue(s string) (float64, bool) {
+	if s == "" || len(s) > 5 || (s[0] != '0' && s[0] != '1') {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+// unquote returns a parameter value without its quotes and escapes. It
+// reports false for a value that is neither a token nor a quoted string.
+func unquote(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s, headers.IsToken(s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		c := s[i]
+		if c == '"' {
+			return "", false
+		}
+		if c == '\\' {
+			if i++; i == len(s)-1 {
+				return "", false
+			}
+			c = s[i]
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), true
+}
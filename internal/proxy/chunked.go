@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// chunkedReader decodes a chunked body (RFC 9112 7.1), returning io.EOF
+// after the last chunk. Chunk extensions and trailers are discarded.
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func newChunkedReader(r *bufio.Reader) *chunkedReader {
+	return &chunkedReader{r: r}
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+
+	if cr.remaining == 0 {
+		size, err := cr.readSize()
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			cr.done = true
+			return 0, cr.skipTrailers()
+		}
+		cr.remaining = size
+	}
+
+	n, err := cr.r.Read(p[:min(int64(len(p)), cr.remaining)])
+	cr.remaining -= int64(n)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+
+	if cr.remaining == 0 {
+		if err := cr.expectCRLF(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (cr *chunkedReader) readSize() (int64, error) {
+	line, err := cr.r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if i := strings.IndexByte(line, ';'); i != -1 {
+		line = line[:i]
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid chunk size %q", line)
+	}
+	return size, nil
+}
+
+func (cr *chunkedReader) expectCRLF() error {
+	line, err := cr.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line != "\r\n" {
+		return fmt.Errorf("missing CRLF after chunk data")
+	}
+	return nil
+}
+
+func (cr *chunkedReader) skipTrailers() error {
+	for {
+		line, err := cr.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line == "\r\n" {
+			return io.EOF
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDialTimeout bounds connecting to an upstream when
+	// Config.DialTimeout is zero.
+	DefaultDialTimeout = 10 * time.Second
+	// DefaultReadTimeout bounds each wait for a forwarded response when
+	// Config.ReadTimeout is zero.
+	DefaultReadTimeout = 30 * time.Second
+)
+
+type Config struct {
+	// DialTimeout bounds how long connecting to the destination may take.
+	DialTimeout time.Duration
+	// ReadTimeout bounds how long a forwarded request waits for the
+	// origin to send more of its response.
+	ReadTimeout time.Duration
+	// Dial, if set, replaces net.DialTimeout for reaching destinations.
+	Dial func(network, address string, timeout time.Duration) (net.Conn, error)
+	// Allow decides whether clients may reach port on host, which resolved
+	// to addr; the proxy dials addr itself. DefaultAllow if nil. Refused
+	// destinations get 403.
+	Allow func(host string, addr netip.Addr, port int) bool
+}
+
+// DefaultAllow lets clients reach ports 80 and 443 on public addresses
+// only, so that the proxy cannot be used to reach the host it runs on or
+// its private network.
+func DefaultAllow(host string, addr netip.Addr, port int) bool {
+	if port != 80 && port != 443 {
+		return false
+	}
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// sharedAddressSpace is carrier-grade NAT space (RFC 6598), which
+// IsPrivate does not cover.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// errForbidden marks a destination that Config.Allow refused.
+var errForbidden = errors.New("destination not allowed")
+
+// hopByHop lists header fields that describe a single connection and must
+// not be forwarded (RFC 9110 7.6.1).
+var hopByHop = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Handler returns a forward proxy. CONNECT requests are tunnelled: the
+// destination is dialled, the client gets 200 and bytes are then copied in
+// both directions until either side closes. Requests with an absolute-form
+// http:// target are forwarded to the origin and the response relayed back.
+// Anything else is rejected with 400. Destinations are checked with
+// Config.Allow, which by default keeps clients to public web servers.
+func Handler(cfg Config) server.Handler {
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = DefaultDialTimeout
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = DefaultReadTimeout
+	}
+	if cfg.Dial == nil {
+		cfg.Dial = net.DialTimeout
+	}
+	if cfg.Allow == nil {
+		cfg.Allow = DefaultAllow
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "CONNECT" {
+			cfg.tunnel(w, req)
+			return
+		}
+		cfg.forward(w, req)
+	}
+}
+
+func (cfg Config) tunnel(w *response.Writer, req *request.Request) {
+	upstream, err := cfg.dial(req.Context(), req.RequestLine.RequestTarget)
+	if err != nil {
+		request.LoggerFromContext(req.Context()).Warn("cannot dial upstream", "category", "dial", "address", req.RequestLine.RequestTarget, "error", err)
+		w.WriteError(dialErrorStatus(err), nil)
+		return
+	}
+
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		upstream.Close()
+		return
+	}
+	if err := w.WriteHeaders(headers.NewHeaders()); err != nil {
+		upstream.Close()
+		return
+	}
+
+	client, rw, err := w.Hijack()
+	if err != nil {
+		request.LoggerFromContext(req.Context()).Error("cannot hijack connection for tunnel", "category", "hijack", "error", err)
+		upstream.Close()
+		return
+	}
+
+	splice(client, rw.Reader, upstream)
+}
+
+// splice copies bytes between the two connections until both directions are
+// done, then closes them. clientReader carries anything the client sent
+// before the tunnel was established.
+func splice(client net.Conn, clientReader io.Reader, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, clientReader)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+	client.Close()
+	upstream.Close()
+}
+
+// closeWrite half-closes conn so the peer sees EOF while the other direction
+// keeps flowing, falling back to a full close.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+func (cfg Config) forward(w *response.Writer, req *request.Request) {
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || target.Scheme != "http" || target.Host == "" {
+		w.WriteError(response.StatusBadRequest, nil)
+		return
+	}
+
+	address := target.Host
+	if target.Port() == "" {
+		address = net.JoinHostPort(target.Hostname(), "80")
+	}
+	logger := request.LoggerFromContext(req.Context()).With("address", address)
+	upstream, err := cfg.dial(req.Context(), address)
+	if err != nil {
+		logger.Warn("cannot dial upstream", "category", "dial", "error", err)
+		w.WriteError(dialErrorStatus(err), nil)
+		return
+	}
+	defer upstream.Close()
+	// A client that goes away abandons the exchange; closing the upstream
+	// unblocks whatever read is pending.
+	stop := context.AfterFunc(req.Context(), func() { upstream.Close() })
+	defer stop()
+
+	if err := writeUpstreamRequest(upstream, req, target); err != nil {
+		logger.Warn("cannot send request upstream", "category", "upstream", "error", err)
+		w.WriteError(response.StatusBadGateway, nil)
+		return
+	}
+
+	br := bufio.NewReader(deadlineReader{upstream, cfg.ReadTimeout})
+	head, err := readResponseHead(br)
+	if err != nil {
+		logger.Warn("cannot read upstream response", "category", "upstream", "error", err)
+		w.WriteError(dialErrorStatus(err), nil)
+		return
+	}
+	statusCode, h := head.statusCode, head.header
+
+	var body io.Reader = br
+	if strings.EqualFold(h.Get("Transfer-Encoding"), "chunked") {
+		body = newChunkedReader(br)
+	} else if cl := h.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			w.WriteError(response.StatusBadGateway, nil)
+			return
+		}
+		body = io.LimitReader(br, n)
+	}
+	removeHopByHop(h)
+	if req.RequestLine.Method == "HEAD" || statusCode == 204 || statusCode == response.StatusNotModified {
+		body = nil
+	} else if h.Get("Content-Length") == "" {
+		// The body is delimited by the upstream closing; re-frame it as
+		// chunks so the client can tell where it ends.
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	for _, c := range head.cookies {
+		if err := w.AddSetCookie(c); err != nil {
+			w.WriteError(response.StatusBadGateway, nil)
+			return
+		}
+	}
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil || body == nil {
+		return
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		logger.Debug("cannot relay upstream body", "category", "write", "error", err)
+	}
+}
+
+// writeUpstreamRequest sends req to the origin in origin-form, without the
+// client's hop-by-hop fields.
+func writeUpstreamRequest(upstream io.Writer, req *request.Request, target *url.URL) error {
+	h := req.Headers.Clone()
+	removeHopByHop(h)
+	h.Set("Host", target.Host)
+	h.Set("Connection", "close")
+	if len(req.Body) > 0 {
+		h.Set("Content-Length", strconv.Itoa(len(req.Body)))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\n", req.RequestLine.Method, target.RequestURI())
+	for key, value := range h {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	buf.WriteString("\r\n")
+	buf.Write(req.Body)
+
+	_, err := upstream.Write(buf.Bytes())
+	return err
+}
+
+// responseHead is the status line and header section of an upstream
+// response. Set-Cookie fields are kept apart, one per line, since their
+// values may contain commas and cannot be combined (RFC 9110 5.3).
+type responseHead struct {
+	statusCode response.StatusCode
+	header     headers.Headers
+	cookies    []string
+}
+
+func readResponseHead(br *bufio.Reader) (*responseHead, error) {
+	for {
+		head, err := readHead(br)
+		if err != nil {
+			return nil, err
+		}
+		// Interim responses such as 100 Continue precede the final one;
+		// only 101 ends the exchange.
+		if head.statusCode >= 200 || head.statusCode == response.StatusSwitchingProtocols {
+			return head, nil
+		}
+	}
+}
+
+// readHead reads one status line and header section.
+func readHead(br *bufio.Reader) (*responseHead, error) {
+	statusLine, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(strings.TrimSpace(statusLine), " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "HTTP/1.") {
+		return nil, fmt.Errorf("malformed status line %q", statusLine)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || code < 100 || code > 999 {
+		return nil, fmt.Errorf("malformed status code %q", parts[1])
+	}
+
+	head := &responseHead{statusCode: response.StatusCode(code), header: headers.NewHeaders()}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Set-Cookie") {
+			head.cookies = append(head.cookies, strings.TrimSpace(value))
+			continue
+		}
+		_, done, err := head.header.Parse([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return head, nil
+		}
+	}
+}
+
+// removeHopByHop deletes the standard hop-by-hop fields plus any listed in
+// the Connection header.
+func removeHopByHop(h headers.Headers) {
+	for _, field := range strings.Split(h.Get("Connection"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			h.Del(field)
+		}
+	}
+	for _, field := range hopByHop {
+		h.Del(field)
+	}
+}
+
+// dial connects to address, a host and port, if cfg.Allow permits it. The
+// host is resolved here, and the address that was checked is the one
+// dialled, so a second DNS answer cannot redirect the connection.
+func (cfg Config) dial(ctx context.Context, address string) (net.Conn, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %q", portText)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if cfg.Allow(host, addr, port) {
+			return cfg.Dial("tcp", net.JoinHostPort(addr.Unmap().String(), portText), cfg.DialTimeout)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", errForbidden, address)
+}
+
+// deadlineReader sets a fresh read deadline on conn before every read, so
+// that a stalled upstream is given up on.
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.conn.Read(p)
+}
+
+func dialErrorStatus(err error) response.StatusCode {
+	if errors.Is(err, errForbidden) {
+		return response.StatusForbidden
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return response.StatusGatewayTimeout
+	}
+	return response.StatusBadGateway
+}
@@ -0,0 +1,336 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen starts a TCP listener on a free local port and runs handle for
+// every accepted connection.
+func listen(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func allowAll(string, netip.Addr, int) bool { return true }
+
+// startProxy serves a proxy that may reach the test's local servers.
+func startProxy(t *testing.T) string {
+	return startProxyWith(t, Config{Allow: allowAll})
+}
+
+// startProxyWith serves the proxy handler the way server.handle does.
+func startProxyWith(t *testing.T, cfg Config) string {
+	handler := Handler(cfg)
+	return listen(t, func(conn net.Conn) {
+		req, err := request.RequestFromReader(conn)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		w := response.NewWriter(conn)
+		w.SetBuffered(req.Buffered())
+		handler(w, req)
+		if !w.Hijacked() {
+			w.Finish()
+			conn.Close()
+		}
+	})
+}
+
+func startEchoServer(t *testing.T) string {
+	return listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+}
+
+func TestConnectTunnel(t *testing.T) {
+	proxyAddr := startProxy(t)
+	echoAddr := startEchoServer(t)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Send the first tunnelled bytes together with the CONNECT request to
+	// check that nothing read ahead by the parser is lost.
+	_, err = conn.Write([]byte("CONNECT " + echoAddr + " HTTP/1.1\r\nHost: " + echoAddr + "\r\n\r\nearly "))
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	statusLine, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	blank, err := br.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "\r\n", blank)
+
+	_, err = conn.Write([]byte("bird"))
+	require.NoError(t, err)
+	conn.(*net.TCPConn).CloseWrite()
+
+	echoed, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Equal(t, "early bird", string(echoed))
+}
+
+func TestConnectUnreachable(t *testing.T) {
+	proxyAddr := startProxy(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("CONNECT " + closedAddr + " HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	statusLine, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 502 Bad Gateway\r\n", statusLine)
+}
+
+func TestForwardAbsoluteForm(t *testing.T) {
+	proxyAddr := startProxy(t)
+
+	received := make(chan *request.Request, 1)
+	originAddr := listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		req, err := request.RequestFromReader(conn)
+		if err != nil {
+			return
+		}
+		received <- req
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Connection: close, X-Hop\r\n" +
+			"X-Hop: secret\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n7\r\n, world\r\n0\r\n\r\n"))
+	})
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST http://" + originAddr + "/submit?x=1 HTTP/1.1\r\n" +
+		"Host: ignored.example\r\n" +
+		"Proxy-Connection: keep-alive\r\n" +
+		"Content-Length: 4\r\n" +
+		"\r\n" +
+		"data"))
+	require.NoError(t, err)
+
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	req := <-received
+	assert.Equal(t, "/submit?x=1", req.RequestLine.RequestTarget)
+	assert.Equal(t, originAddr, req.Headers.Get("Host"))
+	assert.Empty(t, req.Headers.Get("Proxy-Connection"))
+	assert.Equal(t, "data", string(req.Body))
+
+	head, body, found := strings.Cut(string(raw), "\r\n\r\n")
+	require.True(t, found)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, head, "content-type: text/plain")
+	assert.Contains(t, head, "transfer-encoding: chunked")
+	assert.NotContains(t, head, "x-hop")
+
+	decoded, err := io.ReadAll(newChunkedReader(bufio.NewReader(strings.NewReader(body))))
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(decoded))
+}
+
+func TestForwardSkipsInterimResponses(t *testing.T) {
+	proxyAddr := startProxy(t)
+	originAddr := listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		if _, err := request.RequestFromReader(conn); err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	})
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET http://" + originAddr + "/ HTTP/1.1\r\nHost: " + originAddr + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.1 200 OK\r\n"), string(raw))
+	assert.NotContains(t, string(raw), "link:")
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\nok"), string(raw))
+}
+
+func TestForwardKeepsSetCookieLines(t *testing.T) {
+	proxyAddr := startProxy(t)
+	originAddr := listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		if _, err := request.RequestFromReader(conn); err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n" +
+			"Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n" +
+			"set-cookie: b=2; Path=/\r\n" +
+			"Content-Length: 0\r\n\r\n"))
+	})
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET http://" + originAddr + "/ HTTP/1.1\r\nHost: " + originAddr + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "\r\nset-cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n")
+	assert.Contains(t, string(raw), "\r\nset-cookie: b=2; Path=/\r\n")
+}
+
+func TestForwardRejectsOriginForm(t *testing.T) {
+	proxyAddr := startProxy(t)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /local HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+
+	statusLine, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", statusLine)
+}
+
+func TestDefaultAllow(t *testing.T) {
+	tests := []struct {
+		addr string
+		port int
+		want bool
+	}{
+		{"93.184.216.34", 80, true},
+		{"93.184.216.34", 443, true},
+		{"2606:2800:220:1::1", 443, true},
+		{"93.184.216.34", 22, false},
+		{"127.0.0.1", 80, false},
+		{"::1", 80, false},
+		{"::ffff:127.0.0.1", 80, false},
+		{"10.1.2.3", 80, false},
+		{"172.16.0.1", 80, false},
+		{"192.168.1.1", 443, false},
+		{"169.254.169.254", 80, false},
+		{"100.64.0.1", 80, false},
+		{"fd00::1", 80, false},
+		{"0.0.0.0", 80, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, DefaultAllow("example.com", netip.MustParseAddr(tt.addr), tt.port), "%s:%d", tt.addr, tt.port)
+	}
+}
+
+func TestDestinationRefused(t *testing.T) {
+	proxyAddr := startProxyWith(t, Config{})
+	dialled := make(chan struct{}, 1)
+	localAddr := listen(t, func(conn net.Conn) {
+		dialled <- struct{}{}
+		conn.Close()
+	})
+
+	for _, raw := range []string{
+		"CONNECT " + localAddr + " HTTP/1.1\r\nHost: " + localAddr + "\r\n\r\n",
+		"GET http://" + localAddr + "/ HTTP/1.1\r\nHost: " + localAddr + "\r\n\r\n",
+	} {
+		conn, err := net.Dial("tcp", proxyAddr)
+		require.NoError(t, err)
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+		statusLine, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", statusLine)
+	}
+	select {
+	case <-dialled:
+		t.Fatal("refused destination was dialled")
+	default:
+	}
+}
+
+// stalledOrigin reads a request and then never answers.
+func stalledOrigin(t *testing.T) string {
+	return listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		request.RequestFromReader(conn)
+		io.Copy(io.Discard, conn)
+	})
+}
+
+func TestForwardReadTimeout(t *testing.T) {
+	proxyAddr := startProxyWith(t, Config{Allow: allowAll, ReadTimeout: 50 * time.Millisecond})
+	originAddr := stalledOrigin(t)
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET http://" + originAddr + "/ HTTP/1.1\r\nHost: " + originAddr + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	statusLine, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 504 Gateway Timeout\r\n", statusLine)
+}
+
+func TestForwardStopsWhenRequestIsCancelled(t *testing.T) {
+	originAddr := stalledOrigin(t)
+	req, err := request.RequestFromReader(strings.NewReader("GET http://" + originAddr + "/ HTTP/1.1\r\nHost: " + originAddr + "\r\n\r\n"))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	req = req.WithContext(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		var buf strings.Builder
+		Handler(Config{Allow: allowAll})(response.NewWriter(&buf), req)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still waiting on the upstream")
+	}
+}
@@ -0,0 +1,207 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type Algorithm int
+
+const (
+	// TokenBucket refills a bucket of Burst tokens at Limit per Window; each
+	// request takes one. Short bursts are allowed, the long-run rate is not
+	// exceeded.
+	TokenBucket Algorithm = iota
+	// SlidingWindow counts requests in fixed windows and weighs the previous
+	// window's count by how much of it still overlaps the last Window. It
+	// allows at most about Limit requests in any Window-long span.
+	SlidingWindow
+)
+
+// Result describes a key's quota after a call to Allow.
+type Result struct {
+	Allowed bool
+	// Limit is the quota: Burst for a token bucket, Limit for a window.
+	Limit int
+	// Remaining is how many more requests would be allowed right now.
+	Remaining int
+	// Reset is how long until the quota is fully available again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed; it
+	// is zero when Remaining is positive.
+	RetryAfter time.Duration
+}
+
+// Limiter tracks quotas per key in memory. Keys whose quota has fully
+// recovered carry no information and are evicted, so the memory used is
+// bounded by the number of recently active keys. It is safe for concurrent
+// use.
+type Limiter struct {
+	algorithm Algorithm
+	limit     int
+	burst     int
+	window    time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// entry is a key's state; which fields are used depends on the algorithm.
+type entry struct {
+	// Token bucket: tokens left as of last.
+	tokens float64
+	last   time.Time
+
+	// Sliding window: counts for the window starting at start and the one
+	// before it.
+	start     time.Time
+	curr      int
+	prev      int
+	lastTouch time.Time
+}
+
+// NewLimiter returns a limiter allowing limit requests per window for each
+// key. burst is the token bucket's capacity, defaulting to limit; the
+// sliding window ignores it. It panics if limit or window is not positive.
+func NewLimiter(algorithm Algorithm, limit int, window time.Duration, burst int) *Limiter {
+	if limit <= 0 || window <= 0 {
+		panic("ratelimit: limit and window must be positive")
+	}
+	if burst <= 0 {
+		burst = limit
+	}
+	return &Limiter{
+		algorithm: algorithm,
+		limit:     limit,
+		burst:     burst,
+		window:    window,
+		now:       time.Now,
+		entries:   make(map[string]*entry),
+	}
+}
+
+// Allow counts a request for key, if the quota permits it, and reports the
+// quota that remains.
+func (l *Limiter) Allow(key string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	e := l.entries[key]
+	if e == nil {
+		e = &entry{tokens: float64(l.burst), last: now, start: now}
+		l.entries[key] = e
+	}
+	if l.algorithm == SlidingWindow {
+		return l.allowWindow(e, now)
+	}
+	return l.allowBucket(e, now)
+}
+
+// Len returns the number of keys currently tracked.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// rate is the token bucket's refill rate in tokens per second.
+func (l *Limiter) rate() float64 {
+	return float64(l.limit) / l.window.Seconds()
+}
+
+func (l *Limiter) allowBucket(e *entry, now time.Time) Result {
+	e.tokens = math.Min(float64(l.burst), e.tokens+now.Sub(e.last).Seconds()*l.rate())
+	e.last = now
+
+	res := Result{Limit: l.burst}
+	if e.tokens >= 1 {
+		e.tokens--
+		res.Allowed = true
+	}
+	res.Remaining = int(e.tokens)
+	if e.tokens < 1 {
+		res.RetryAfter = seconds((1 - e.tokens) / l.rate())
+	}
+	res.Reset = seconds((float64(l.burst) - e.tokens) / l.rate())
+	return res
+}
+
+func (l *Limiter) allowWindow(e *entry, now time.Time) Result {
+	l.advance(e, now)
+	e.lastTouch = now
+	elapsed := now.Sub(e.start)
+	weight := 1 - float64(elapsed)/float64(l.window)
+	estimate := func() float64 { return float64(e.prev)*weight + float64(e.curr) }
+
+	res := Result{Limit: l.limit}
+	if estimate() < float64(l.limit) {
+		e.curr++
+		res.Allowed = true
+	}
+	res.Remaining = max(0, l.limit-int(math.Ceil(estimate())))
+	if res.Remaining == 0 {
+		res.RetryAfter = l.windowWait(e, elapsed)
+	}
+	// Both windows' counts have aged out once the next window is over.
+	res.Reset = 2*l.window - elapsed
+	if e.curr == 0 {
+		res.Reset = l.window - elapsed
+	}
+	return res
+}
+
+// advance moves e's windows forward to the one containing now.
+func (l *Limiter) advance(e *entry, now time.Time) {
+	passed := now.Sub(e.start) / l.window
+	switch {
+	case passed == 1:
+		e.prev, e.curr = e.curr, 0
+	case passed > 1:
+		e.prev, e.curr = 0, 0
+	}
+	e.start = e.start.Add(passed * l.window)
+}
+
+// windowWait returns how long until the weighted count drops below the
+// limit, leaving room for one more request.
+func (l *Limiter) windowWait(e *entry, elapsed time.Duration) time.Duration {
+	limit, w := float64(l.limit), float64(l.window)
+	if e.curr < l.limit {
+		// Wait for the previous window's share to shrink enough.
+		at := w * (1 - (limit-float64(e.curr))/float64(e.prev))
+		return time.Duration(at) - elapsed + time.Nanosecond
+	}
+	// Wait for the next window, where this one's count is the previous.
+	at := w * (1 - limit/float64(e.curr))
+	return l.window - elapsed + time.Duration(at) + time.Nanosecond
+}
+
+// sweep evicts entries whose quota has fully recovered, at most once per
+// window so that the cost is spread over many calls.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	refill := seconds(float64(l.burst) / l.rate())
+	for key, e := range l.entries {
+		var idle bool
+		if l.algorithm == SlidingWindow {
+			idle = now.Sub(e.lastTouch) >= 2*l.window
+		} else {
+			idle = now.Sub(e.last) >= refill
+		}
+		if idle {
+			delete(l.entries, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"math"
+	"strconv"
+	"time"
+)
+
+// DefaultWindow is the period Config.Limit applies to when Config.Window is
+// zero.
+const DefaultWindow = time.Minute
+
+type Config struct {
+	// Limit is the number of requests a client may make per Window. It
+	// must be positive.
+	Limit  int
+	Window time.Duration
+	// Burst is the token bucket's capacity; zero means Limit.
+	Burst     int
+	Algorithm Algorithm
+	// Key names the client a request is counted against, e.g. ByHeader
+	// for an API key. Requests for which it is nil or returns "" are
+	// counted against their client IP.
+	Key func(req *request.Request) string
+	// TrustedProxies are consulted to find the client IP behind proxies.
+	// Peers on a Unix socket have no IP: unless "unix" is trusted and the
+	// proxy there forwards the client address, they all share one quota.
+	TrustedProxies request.TrustedProxies
+}
+
+// ByHeader keys requests by the value of the named header.
+func ByHeader(name string) func(req *request.Request) string {
+	return func(req *request.Request) string {
+		return req.Headers.Get(name)
+	}
+}
+
+// Middleware returns middleware that limits how often each client may make
+// requests. Requests over the limit get 429 Too Many Requests with
+// Retry-After; every response carries RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers describing the client's quota, with
+// RateLimit-Policy giving the limit and window.
+func Middleware(cfg Config) func(next server.Handler) server.Handler {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+	limiter := NewLimiter(cfg.Algorithm, cfg.Limit, cfg.Window, cfg.Burst)
+	policy := strconv.Itoa(cfg.Limit) + ";w=" + strconv.Itoa(ceilSeconds(cfg.Window))
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			res := limiter.Allow(cfg.key(req))
+			setHeaders := func(h headers.Headers) {
+				h.Set("RateLimit-Policy", policy)
+				h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+				h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+				h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			}
+
+			if !res.Allowed {
+				request.LoggerFromContext(req.Context()).Debug("rate limit exceeded", "category", "ratelimit")
+				h := headers.NewHeaders()
+				setHeaders(h)
+				h.Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(res.RetryAfter))))
+				w.WriteError(response.StatusTooManyRequests, h)
+				return
+			}
+
+			w.OnWriteHeaders(func(statusCode response.StatusCode, h headers.Headers) {
+				setHeaders(h)
+			})
+			next(w, req)
+		}
+	}
+}
+
+func (cfg Config) key(req *request.Request) string {
+	if cfg.Key != nil {
+		if key := cfg.Key(req); key != "" {
+			// Kept apart from IP keys so that a client cannot spend someone
+			// else's quota by sending their address as its key.
+			return "key " + key
+		}
+	}
+	return "ip " + req.ClientIP(cfg.TrustedProxies).String()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock returns a limiter whose time only moves when advance is called.
+func fakeClock(l *Limiter) (advance func(time.Duration)) {
+	now := time.Unix(1_700_000_000, 0)
+	l.now = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestTokenBucket(t *testing.T) {
+	l := NewLimiter(TokenBucket, 2, time.Second, 4)
+	advance := fakeClock(l)
+
+	for i := 3; i >= 0; i-- {
+		res := l.Allow("a")
+		require.True(t, res.Allowed)
+		assert.Equal(t, 4, res.Limit)
+		assert.Equal(t, i, res.Remaining)
+	}
+	res := l.Allow("a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+	assert.Equal(t, 2*time.Second, res.Reset)
+
+	// Other keys have their own bucket.
+	assert.True(t, l.Allow("b").Allowed)
+
+	advance(500 * time.Millisecond)
+	assert.True(t, l.Allow("a").Allowed)
+	assert.False(t, l.Allow("a").Allowed)
+
+	advance(time.Hour)
+	res = l.Allow("a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 3, res.Remaining, "refill is capped at the burst")
+}
+
+func TestSlidingWindow(t *testing.T) {
+	l := NewLimiter(SlidingWindow, 4, 10*time.Second, 0)
+	advance := fakeClock(l)
+
+	for i := 3; i >= 0; i-- {
+		res := l.Allow("a")
+		require.True(t, res.Allowed)
+		assert.Equal(t, i, res.Remaining)
+	}
+	res := l.Allow("a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 10*time.Second+time.Nanosecond, res.RetryAfter)
+	assert.Equal(t, 20*time.Second, res.Reset)
+
+	// A fifth into the next window, the previous 4 still weigh 3.2.
+	advance(12 * time.Second)
+	res = l.Allow("a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	res = l.Allow("a")
+	assert.False(t, res.Allowed)
+	// One more request fits once they weigh under 3.
+	assert.Equal(t, 500*time.Millisecond+time.Nanosecond, res.RetryAfter)
+
+	advance(500 * time.Millisecond)
+	assert.False(t, l.Allow("a").Allowed)
+	advance(time.Nanosecond)
+	assert.True(t, l.Allow("a").Allowed)
+
+	advance(time.Minute)
+	res = l.Allow("a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 3, res.Remaining)
+}
+
+func TestEviction(t *testing.T) {
+	for _, algorithm := range []Algorithm{TokenBucket, SlidingWindow} {
+		l := NewLimiter(algorithm, 10, time.Second, 0)
+		advance := fakeClock(l)
+		for i := range 100 {
+			l.Allow(fmt.Sprint(i))
+		}
+		assert.Equal(t, 100, l.Len())
+
+		advance(time.Second)
+		l.Allow("active")
+		advance(time.Second)
+		l.Allow("active")
+		assert.Equal(t, 1, l.Len(), "algorithm %d", algorithm)
+	}
+}
+
+func run(t *testing.T, mw func(next server.Handler) server.Handler, rawRequest string) string {
+	t.Helper()
+	return runFrom(t, mw, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}, rawRequest)
+}
+
+func runFrom(t *testing.T, mw func(next server.Handler) server.Handler, remote net.Addr, rawRequest string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+	require.NoError(t, err)
+	req.RemoteAddr = remote
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	mw(func(w *response.Writer, req *request.Request) {
+		w.WriteError(response.StatusOK, headers.NewHeaders())
+	})(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func TestMiddleware(t *testing.T) {
+	mw := Middleware(Config{Limit: 2, Window: time.Minute})
+	get := "GET / HTTP/1.1\r\n\r\n"
+
+	out := run(t, mw, get)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "ratelimit-policy: 2;w=60\r\n")
+	assert.Contains(t, out, "ratelimit-limit: 2\r\n")
+	assert.Contains(t, out, "ratelimit-remaining: 1\r\n")
+	assert.Contains(t, out, "ratelimit-reset: 30\r\n")
+
+	run(t, mw, get)
+	out = run(t, mw, get)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests\r\n"), out)
+	assert.Contains(t, out, "retry-after: 30\r\n")
+	assert.Contains(t, out, "ratelimit-remaining: 0\r\n")
+}
+
+func TestMiddlewareKey(t *testing.T) {
+	mw := Middleware(Config{Limit: 1, Key: ByHeader("X-API-Key")})
+
+	assert.Contains(t, run(t, mw, "GET / HTTP/1.1\r\nX-API-Key: one\r\n\r\n"), " 200 OK")
+	assert.Contains(t, run(t, mw, "GET / HTTP/1.1\r\nX-API-Key: one\r\n\r\n"), " 429 ")
+	assert.Contains(t, run(t, mw, "GET / HTTP/1.1\r\nX-API-Key: two\r\n\r\n"), " 200 OK")
+
+	// Without a key the client IP is used, which a key naming it cannot
+	// use up.
+	assert.Contains(t, run(t, mw, "GET / HTTP/1.1\r\nX-API-Key: 192.0.2.1\r\n\r\n"), " 200 OK")
+	assert.Contains(t, run(t, mw, "GET / HTTP/1.1\r\n\r\n"), " 200 OK")
+	assert.Contains(t, run(t, mw, "GET / HTTP/1.1\r\n\r\n"), " 429 ")
+}
+
+func TestMiddlewareBehindUnixSocket(t *testing.T) {
+	proxy := &net.UnixAddr{Name: "@", Net: "unix"}
+	from := func(client string) string {
+		return "GET / HTTP/1.1\r\nX-Forwarded-For: " + client + "\r\n\r\n"
+	}
+
+	// Untrusted, the forwarded addresses are ignored and every client
+	// shares the socket's quota.
+	mw := Middleware(Config{Limit: 1})
+	assert.Contains(t, runFrom(t, mw, proxy, from("198.51.100.1")), " 200 OK")
+	assert.Contains(t, runFrom(t, mw, proxy, from("198.51.100.2")), " 429 ")
+
+	trusted, err := request.ParseTrustedProxies("unix")
+	require.NoError(t, err)
+	mw = Middleware(Config{Limit: 1, TrustedProxies: trusted})
+	assert.Contains(t, runFrom(t, mw, proxy, from("198.51.100.1")), " 200 OK")
+	assert.Contains(t, runFrom(t, mw, proxy, from("198.51.100.1")), " 429 ")
+	assert.Contains(t, runFrom(t, mw, proxy, from("198.51.100.2")), " 200 OK")
+}
@@ -0,0 +1,150 @@
+package request
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies lists the networks whose forwarding headers are believed
+// when resolving the client address. The zero Prefix, which
+// ParseTrustedProxies produces for "unix", stands for peers on Unix domain
+// sockets.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses CIDR prefixes or single addresses, e.g.
+// "10.0.0.0/8" or "127.0.0.1", and "unix" to trust whatever connects over
+// a Unix domain socket, such as a local reverse proxy. Any local process
+// that can reach the socket is then believed.
+func ParseTrustedProxies(networks ...string) (TrustedProxies, error) {
+	trusted := make(TrustedProxies, 0, len(networks))
+	for _, network := range networks {
+		if network == "unix" {
+			trusted = append(trusted, netip.Prefix{})
+			continue
+		}
+		if strings.Contains(network, "/") {
+			prefix, err := netip.ParsePrefix(network)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", network, err)
+			}
+			trusted = append(trusted, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", network, err)
+		}
+		addr = addr.Unmap()
+		trusted = append(trusted, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return trusted, nil
+}
+
+// trusts reports whether the peer at a is a trusted proxy.
+func (tp TrustedProxies) trusts(a net.Addr) bool {
+	if _, ok := a.(*net.UnixAddr); ok {
+		for _, prefix := range tp {
+			if prefix == (netip.Prefix{}) {
+				return true
+			}
+		}
+		return false
+	}
+	return tp.contains(addrOf(a))
+}
+
+func (tp TrustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range tp {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that originated the request.
+// Forwarding headers are only consulted when the peer itself is a trusted
+// proxy; the Forwarded header (RFC 7239) is preferred over X-Forwarded-For.
+// The chain of hops is walked from the nearest one outwards, skipping trusted
+// proxies, and the first untrusted address is the client. An unparsable hop
+// stops the walk at the last address known to be genuine. The zero Addr is
+// returned when the peer address is not an IP address and no forwarded
+// address can be believed, as for a Unix-socket peer that is not trusted.
+func (r *Request) ClientIP(trusted TrustedProxies) netip.Addr {
+	addr := addrOf(r.RemoteAddr)
+	if !trusted.trusts(r.RemoteAddr) {
+		return addr
+	}
+
+	var hops []string
+	if forwarded := r.Headers.Get("Forwarded"); forwarded != "" {
+		hops = forwardedFor(forwarded)
+	} else if xff := r.Headers.Get("X-Forwarded-For"); xff != "" {
+		hops = strings.Split(xff, ",")
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			return addr
+		}
+		addr = hop
+		if !trusted.contains(addr) {
+			return addr
+		}
+	}
+	return addr
+}
+
+func addrOf(a net.Addr) netip.Addr {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		addr, _ := netip.AddrFromSlice(a.IP)
+		return addr.Unmap()
+	case nil:
+		return netip.Addr{}
+	default:
+		addrPort, err := netip.ParseAddrPort(a.String())
+		if err != nil {
+			return netip.Addr{}
+		}
+		return addrPort.Addr().Unmap()
+	}
+}
+
+// parseHop parses a node from X-Forwarded-For or a Forwarded "for"
+// parameter: a bare IP, an IP with port, or a bracketed IPv6 address.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// forwardedFor extracts the "for" parameter of each element of a Forwarded
+// header, in order. Elements without one yield an empty string, which
+// parseHop rejects.
+func forwardedFor(value string) []string {
+	var hops []string
+	for _, element := range headers.SplitQuoted(value, ',') {
+		hop := ""
+		for _, pair := range headers.SplitQuoted(element, ';') {
+			key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(strings.TrimSpace(key), "for") {
+				hop = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
@@ -0,0 +1,75 @@
+package request
+
+import (
+	"context"
+	"log/slog"
+	"net"
+)
+
+type contextKey int
+
+const (
+	idKey contextKey = iota
+	remoteAddrKey
+	loggerKey
+)
+
+// Context returns the request's context. The server cancels it when the
+// client disconnects, the server shuts down or the request times out. It is
+// never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r carrying ctx, for middleware that
+// adds values or deadlines before calling the next handler.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("request: nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// ContextWithID returns a copy of ctx carrying the request ID.
+func ContextWithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey, id)
+}
+
+// IDFromContext returns the request ID stored in ctx, or "".
+func IDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(idKey).(string)
+	return id
+}
+
+// ContextWithRemoteAddr returns a copy of ctx carrying the client address.
+func ContextWithRemoteAddr(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, remoteAddrKey, addr)
+}
+
+// RemoteAddrFromContext returns the client address stored in ctx, or nil.
+func RemoteAddrFromContext(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey).(net.Addr)
+	return addr
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// ContextWithLogger returns a copy of ctx carrying logger.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// LoggerFromContext returns the logger stored in ctx. The server stores one
+// tagged with the connection and request IDs. Without one, the returned
+// logger discards everything.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return discardLogger
+}
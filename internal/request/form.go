@@ -0,0 +1,221 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Form parsing errors. ParseForm wraps one of these so that a handler can
+// answer 415, 400 or 413 respectively.
+var (
+	ErrNotForm       = errors.New("request body is not a form")
+	ErrMalformedForm = errors.New("malformed form")
+	ErrFormTooLarge  = errors.New("form too large")
+)
+
+const (
+	// DefaultMaxMemory is how many bytes of uploaded files are kept in
+	// memory when FormLimits.MaxMemory is zero.
+	DefaultMaxMemory = 1 << 20
+	// DefaultMaxParts bounds the parts of a multipart form when
+	// FormLimits.MaxParts is zero.
+	DefaultMaxParts = 1000
+	// DefaultMaxPartHeaderBytes bounds each part's header section when
+	// FormLimits.MaxPartHeaderBytes is zero.
+	DefaultMaxPartHeaderBytes = 16 << 10
+)
+
+// FormLimits bound form parsing, on top of the body size limit the request
+// was read with. Zero fields mean the defaults.
+type FormLimits struct {
+	// MaxMemory is how many bytes of files are kept in memory in total;
+	// larger files go to temporary files on disk.
+	MaxMemory int64
+	// MaxFileSize bounds each uploaded file; zero means no limit.
+	MaxFileSize int64
+	// MaxParts bounds the number of fields and files.
+	MaxParts int
+	// MaxPartHeaderBytes bounds the header section of each multipart part.
+	MaxPartHeaderBytes int
+}
+
+func (l FormLimits) withDefaults() FormLimits {
+	if l.MaxMemory <= 0 {
+		l.MaxMemory = DefaultMaxMemory
+	}
+	if l.MaxParts <= 0 {
+		l.MaxParts = DefaultMaxParts
+	}
+	if l.MaxPartHeaderBytes <= 0 {
+		l.MaxPartHeaderBytes = DefaultMaxPartHeaderBytes
+	}
+	return l
+}
+
+// Form is a parsed form body.
+type Form struct {
+	Values url.Values
+	// Files holds the uploaded files of a multipart form by field name.
+	Files map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file.
+type FileHeader struct {
+	// Filename is the name the client gave, without any directory.
+	Filename string
+	// Header holds the part's headers, such as Content-Type.
+	Header headers.Headers
+	Size   int64
+
+	content []byte
+	tmpFile string
+}
+
+// File is an uploaded file opened with FileHeader.Open.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Open returns the file's contents.
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpFile != "" {
+		return os.Open(fh.tmpFile)
+	}
+	return nopCloser{bytes.NewReader(fh.content)}, nil
+}
+
+// RemoveAll deletes the temporary files that hold uploaded files larger
+// than FormLimits.MaxMemory. It is safe to call more than once; the server
+// calls it through Request.RemoveFormFiles after every handler.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, fh := range files {
+			if fh.tmpFile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// formCache holds the parsed form. Requests copied by WithContext share
+// it, so the form is parsed once and its files are removed once.
+type formCache struct {
+	mu     sync.Mutex
+	parsed bool
+	form   *Form
+	err    error
+}
+
+// Query returns the parameters of the request target's query string.
+// Malformed pairs are skipped.
+func (r *Request) Query() url.Values {
+	_, query, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	values, _ := url.ParseQuery(query)
+	return values
+}
+
+// ParseForm parses an application/x-www-form-urlencoded or
+// multipart/form-data body. Files of a multipart form beyond the limits'
+// MaxMemory are written to temporary files, which the server removes once
+// the handler returns. The result is cached: later calls return it
+// whatever limits they pass. Errors wrap ErrNotForm, ErrMalformedForm or
+// ErrFormTooLarge.
+func (r *Request) ParseForm(limits FormLimits) (*Form, error) {
+	cache := r.formCache()
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if !cache.parsed {
+		cache.form, cache.err = r.parseForm(limits.withDefaults())
+		cache.parsed = true
+	}
+	return cache.form, cache.err
+}
+
+// FormValue returns the first value for name from the form body, parsed
+// with default limits, or else from the query string. It returns "" if
+// there is none, including when the body is not a valid form.
+func (r *Request) FormValue(name string) string {
+	if form, err := r.ParseForm(FormLimits{}); err == nil {
+		if values, ok := form.Values[name]; ok && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return r.Query().Get(name)
+}
+
+// RemoveFormFiles deletes the temporary files ParseForm created. The server
+// calls it after every handler.
+func (r *Request) RemoveFormFiles() error {
+	if r.form == nil {
+		return nil
+	}
+	r.form.mu.Lock()
+	defer r.form.mu.Unlock()
+	if r.form.form == nil {
+		return nil
+	}
+	return r.form.form.RemoveAll()
+}
+
+func (r *Request) formCache() *formCache {
+	if r.form == nil {
+		r.form = &formCache{}
+	}
+	return r.form
+}
+
+func (r *Request) parseForm(limits FormLimits) (*Form, error) {
+	contentType := r.Headers.Get("Content-Type")
+	if contentType == "" {
+		return nil, fmt.Errorf("%w: no Content-Type", ErrNotForm)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotForm, err)
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return parseURLEncoded(r.Body, limits)
+	case "multipart/form-data":
+		boundary := params["boundary"]
+		if !validBoundary(boundary) {
+			return nil, fmt.Errorf("%w: invalid boundary %q", ErrMalformedForm, boundary)
+		}
+		return parseMultipart(r.Body, boundary, limits)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotForm, mediaType)
+}
+
+func parseURLEncoded(body []byte, limits FormLimits) (*Form, error) {
+	if n := bytes.Count(body, []byte("&")) + 1; n > limits.MaxParts {
+		return nil, fmt.Errorf("%w: more than %d fields", ErrFormTooLarge, limits.MaxParts)
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedForm, err)
+	}
+	return &Form{Values: values, Files: map[string][]*FileHeader{}}, nil
+}
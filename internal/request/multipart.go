@@ -0,0 +1,147 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"mime"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var crlf = []byte("\r\n")
+
+// validBoundary checks a multipart boundary against RFC 2046 5.1.1.
+func validBoundary(boundary string) bool {
+	if boundary == "" || len(boundary) > 70 || strings.HasSuffix(boundary, " ") {
+		return false
+	}
+	for i := 0; i < len(boundary); i++ {
+		c := boundary[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			continue
+		}
+		if strings.IndexByte(`'()+_,-./:=? `, c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMultipart parses a multipart/form-data body (RFC 7578). The body is
+// already in memory, so parts are located by searching for the delimiter;
+// file contents are copied to disk once MaxMemory is used up.
+func parseMultipart(body []byte, boundary string, limits FormLimits) (_ *Form, err error) {
+	form := &Form{Values: url.Values{}, Files: map[string][]*FileHeader{}}
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+		}
+	}()
+
+	// The first delimiter starts the body or a line after the preamble;
+	// the others end the preceding part's content, CRLF included.
+	delimiter := []byte("--" + boundary)
+	next := append([]byte("\r\n"), delimiter...)
+	var rest []byte
+	if bytes.HasPrefix(body, delimiter) {
+		rest = body[len(delimiter):]
+	} else if i := bytes.Index(body, next); i >= 0 {
+		rest = body[i+len(next):]
+	} else {
+		return nil, fmt.Errorf("%w: no boundary found", ErrMalformedForm)
+	}
+
+	memory := limits.MaxMemory
+	for parts := 0; ; parts++ {
+		if bytes.HasPrefix(rest, []byte("--")) {
+			// Close delimiter; anything after it is an epilogue.
+			return form, nil
+		}
+		rest = bytes.TrimLeft(rest, " \t")
+		if !bytes.HasPrefix(rest, crlf) {
+			return nil, fmt.Errorf("%w: garbage after boundary", ErrMalformedForm)
+		}
+		rest = rest[len(crlf):]
+		if parts == limits.MaxParts {
+			return nil, fmt.Errorf("%w: more than %d parts", ErrFormTooLarge, limits.MaxParts)
+		}
+
+		h, n, err := parsePartHeaders(rest, limits.MaxPartHeaderBytes)
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[n:]
+		end := bytes.Index(rest, next)
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated part", ErrMalformedForm)
+		}
+		content := rest[:end]
+		rest = rest[end+len(next):]
+
+		disposition, params, err := mime.ParseMediaType(h.Get("Content-Disposition"))
+		if err != nil || disposition != "form-data" || params["name"] == "" {
+			// Not a form field (RFC 7578 4.2); skip it.
+			continue
+		}
+		name := params["name"]
+		filename := params["filename"]
+		if filename == "" {
+			form.Values.Add(name, string(content))
+			continue
+		}
+
+		if limits.MaxFileSize > 0 && int64(len(content)) > limits.MaxFileSize {
+			return nil, fmt.Errorf("%w: file %q is larger than %d bytes", ErrFormTooLarge, filename, limits.MaxFileSize)
+		}
+		// Some clients send the full path, with either kind of separator.
+		filename = filename[strings.LastIndexAny(filename, `/\`)+1:]
+		fh := &FileHeader{Filename: filename, Header: h, Size: int64(len(content))}
+		if fh.Size <= memory {
+			memory -= fh.Size
+			fh.content = content
+		} else if fh.tmpFile, err = spill(content); err != nil {
+			return nil, err
+		}
+		form.Files[name] = append(form.Files[name], fh)
+	}
+}
+
+func parsePartHeaders(data []byte, maxBytes int) (headers.Headers, int, error) {
+	h := headers.NewHeaders()
+	consumed := 0
+	for {
+		n, done, err := h.Parse(data[consumed:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: part header: %w", ErrMalformedForm, err)
+		}
+		consumed += n
+		if consumed > maxBytes {
+			return nil, 0, fmt.Errorf("%w: part header larger than %d bytes", ErrFormTooLarge, maxBytes)
+		}
+		if done {
+			return h, consumed, nil
+		}
+		if n == 0 {
+			return nil, 0, fmt.Errorf("%w: unterminated part header", ErrMalformedForm)
+		}
+	}
+}
+
+// spill writes an uploaded file's contents to a temporary file.
+func spill(content []byte) (string, error) {
+	f, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return "", fmt.Errorf("cannot store uploaded file: %w", err)
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("cannot store uploaded file: %w", err)
+	}
+	return f.Name(), nil
+}
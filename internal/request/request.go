@@ -2,18 +2,81 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
+	"net"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
+
+	// Connection metadata, filled in by the server. RequestFromReader
+	// alone leaves these zero.
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	// ConnID identifies the connection, unique within a server process.
+	ConnID uint64
+	// Sequence is the 1-based position of this request on its connection.
+	Sequence int
+	// TLS is the state of the TLS connection, or nil for plain TCP.
+	TLS *tls.ConnectionState
+
 	state       RequestStatus
+	buffered    []byte
+	ctx         context.Context
+	limits      Limits
+	headerBytes int
+	form        *formCache
+}
+
+// Parse errors returned by RequestFromReader wrap one of these, so callers
+// can tell what was wrong with errors.Is. Errors from the reader itself are
+// returned as they are.
+var (
+	ErrMalformedRequestLine = errors.New("malformed request line")
+	ErrUnsupportedVersion   = errors.New("unsupported HTTP version")
+	ErrInvalidMethod        = errors.New("invalid method")
+	ErrInvalidTarget        = errors.New("invalid request target")
+	ErrMalformedHeader      = errors.New("malformed header")
+	ErrInvalidContentLength = errors.New("invalid Content-Length")
+	ErrIncomplete           = errors.New("connection closed before request was fully parsed")
+	ErrHeaderTooLarge       = errors.New("request line and headers too large")
+	ErrBodyTooLarge         = errors.New("request body too large")
+)
+
+const (
+	// DefaultMaxHeaderBytes bounds the request line and headers together
+	// when Limits.MaxHeaderBytes is zero.
+	DefaultMaxHeaderBytes = 1 << 20
+	// DefaultMaxBodyBytes bounds the body when Limits.MaxBodyBytes is zero.
+	DefaultMaxBodyBytes = 10 << 20
+)
+
+// Limits bounds how much a request may make the parser buffer. Zero fields
+// mean the defaults.
+type Limits struct {
+	MaxHeaderBytes int
+	MaxBodyBytes   int
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if l.MaxBodyBytes <= 0 {
+		l.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	return l
 }
 
 type RequestStatus int
@@ -35,16 +98,32 @@ func (r *Request) done() bool {
 	return r.state == StateDone
 }
 
+// Buffered returns bytes that RequestFromReader read from the reader past
+// the end of this request. On a connection these belong to whatever the
+// client sent next, e.g. the first frames of an upgraded protocol.
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
 // RequestFromReader reads and parses an HTTP request from the provided reader.
 // It incrementally reads data and parses the request line and headers.
 // Returns a fully parsed Request or an error if parsing fails.
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderWithLimits(reader, Limits{})
+}
+
+// RequestFromReaderWithLimits is RequestFromReader with explicit size limits.
+// A request exceeding them fails with ErrHeaderTooLarge or ErrBodyTooLarge
+// as soon as that is known, without reading the rest.
+func RequestFromReaderWithLimits(reader io.Reader, limits Limits) (*Request, error) {
 	const bufferSize = 8
 	buf := make([]byte, bufferSize)
 	readToIndex := 0
 
 	request := &Request{
-		state: StateInit,
+		state:  StateInit,
+		limits: limits.withDefaults(),
+		form:   &formCache{},
 	}
 
 	for !request.done() {
@@ -80,11 +159,20 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 			}
 		}
 
+		// Until the header section ends, unparsed input is part of it.
+		headerBytes := request.headerBytes
+		if request.state < StateBody {
+			headerBytes += readToIndex
+		}
+		if headerBytes > request.limits.MaxHeaderBytes {
+			return nil, ErrHeaderTooLarge
+		}
+
 		// Handle read errors after parsing
 		if readErr != nil {
 			if readErr == io.EOF {
 				if !request.done() {
-					return nil, fmt.Errorf("connection closed before request was fully parsed")
+					return nil, ErrIncomplete
 				}
 				break
 			}
@@ -92,6 +180,9 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		}
 	}
 
+	if readToIndex > 0 {
+		request.buffered = append([]byte(nil), buf[:readToIndex]...)
+	}
 	return request, nil
 }
 
@@ -113,6 +204,7 @@ func (r *Request) parse(data []byte) (int, error) {
 
 			r.RequestLine = *rl
 			bytesConsumed += n
+			r.headerBytes += n
 			r.state = StateHeaders
 
 		case StateHeaders:
@@ -121,13 +213,14 @@ func (r *Request) parse(data []byte) (int, error) {
 			}
 			n, done, err := r.Headers.Parse(data[bytesConsumed:])
 			if err != nil {
-				return 0, err
+				return 0, fmt.Errorf("%w: %w", ErrMalformedHeader, err)
 			}
 			if n == 0 {
 				return bytesConsumed, nil
 			}
 
 			bytesConsumed += n
+			r.headerBytes += n
 
 			if done {
 				r.state = StateBody
@@ -144,7 +237,11 @@ func (r *Request) parse(data []byte) (int, error) {
 
 			contentLength, convErr := strconv.Atoi(contentLengthStr)
 			if convErr != nil || contentLength < 0 {
-				return 0, fmt.Errorf("invalid Content-Length: %q", contentLengthStr)
+				return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, contentLengthStr)
+			}
+
+			if contentLength > r.limits.MaxBodyBytes {
+				return 0, fmt.Errorf("%w: Content-Length %d exceeds %d", ErrBodyTooLarge, contentLength, r.limits.MaxBodyBytes)
 			}
 
 			if contentLength == 0 {
@@ -195,23 +292,27 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 
 	parts := bytes.Split(requestLineData, []byte(" "))
 	if len(parts) != 3 {
-		return nil, 0, fmt.Errorf("request line must have 3 parts, got %d", len(parts))
+		return nil, 0, fmt.Errorf("%w: must have 3 parts, got %d", ErrMalformedRequestLine, len(parts))
 	}
 
 	method, target, versionData := parts[0], parts[1], parts[2]
 
 	versionParts := bytes.Split(versionData, []byte("/"))
 	if len(versionParts) != 2 || !bytes.Equal(versionParts[0], []byte("HTTP")) {
-		return nil, 0, fmt.Errorf("invalid HTTP version format: %s", versionData)
+		return nil, 0, fmt.Errorf("%w: invalid HTTP version format: %s", ErrMalformedRequestLine, versionData)
 	}
 
 	version := versionParts[1]
 	if !bytes.Equal(version, []byte("1.1")) {
-		return nil, 0, fmt.Errorf("invalid HTTP version: %s, only 1.1 is supported", version)
+		return nil, 0, fmt.Errorf("%w: %s, only 1.1 is supported", ErrUnsupportedVersion, version)
 	}
 
 	if !validMethod.Match(method) {
-		return nil, 0, fmt.Errorf("invalid method: %s", method)
+		return nil, 0, fmt.Errorf("%w: %s", ErrInvalidMethod, method)
+	}
+
+	if err := validateTarget(string(method), string(target)); err != nil {
+		return nil, 0, err
 	}
 
 	return &RequestLine{
@@ -220,3 +321,52 @@ func parseRequestLine(data []byte) (*RequestLine, int, error) {
 		Method:        string(method),
 	}, bytesConsumed, nil
 }
+
+// validateTarget checks that the request target uses a form allowed for the
+// method (RFC 9112 3.2): authority-form for CONNECT only, asterisk-form for
+// OPTIONS only, and origin-form or absolute-form for everything else.
+func validateTarget(method, target string) error {
+	if target == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidTarget)
+	}
+
+	if method == "CONNECT" {
+		if _, _, err := SplitAuthority(target); err != nil {
+			return fmt.Errorf("%w: CONNECT target %q: %w", ErrInvalidTarget, target, err)
+		}
+		return nil
+	}
+
+	switch {
+	case target == "*":
+		if method != "OPTIONS" {
+			return fmt.Errorf("%w: asterisk-form is only allowed for OPTIONS", ErrInvalidTarget)
+		}
+	case strings.HasPrefix(target, "/"):
+	case strings.Contains(target, "://"):
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("%w: absolute-form %q", ErrInvalidTarget, target)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+	return nil
+}
+
+// SplitAuthority splits an authority-form target (host:port) into its host
+// and port. IPv6 literals must be bracketed, and the brackets are removed.
+func SplitAuthority(target string) (host string, port int, err error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", 0, err
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("missing host")
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, port, nil
+}
@@ -1,7 +1,13 @@
 package request
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
 	"strings"
 	"testing"
 
@@ -46,6 +52,39 @@ func TestRequestFromReader(t *testing.T) {
 	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.234\r\n\r\n"))
 	require.Error(t, err)
 
+	// Test: CONNECT with authority-form target
+	r, err = RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+
+	// Test: CONNECT with IPv6 authority-form target
+	_, err = RequestFromReader(strings.NewReader("CONNECT [::1]:8080 HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: CONNECT without a port
+	_, err = RequestFromReader(strings.NewReader("CONNECT example.com HTTP/1.1\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: CONNECT with origin-form target
+	_, err = RequestFromReader(strings.NewReader("CONNECT / HTTP/1.1\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Authority-form target on a non-CONNECT method
+	_, err = RequestFromReader(strings.NewReader("GET example.com:80 HTTP/1.1\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Absolute-form target
+	r, err = RequestFromReader(strings.NewReader("GET http://example.com/coffee?x=1 HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/coffee?x=1", r.RequestLine.RequestTarget)
+
+	// Test: Asterisk-form target
+	_, err = RequestFromReader(strings.NewReader("OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = RequestFromReader(strings.NewReader("GET * HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.Error(t, err)
+
 	// Test: Empty reader
 	_, err = RequestFromReader(strings.NewReader(""))
 	require.Error(t, err)
@@ -243,3 +282,314 @@ func TestRequestBodyParse(t *testing.T) {
 		assert.True(t, r.done())
 	})
 }
+
+func TestRequestBuffered(t *testing.T) {
+	t.Run("Bytes after the body are kept", func(t *testing.T) {
+		reader := &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Content-Length: 5\r\n" +
+				"\r\n" +
+				"hello" +
+				"GET /next HTTP/1.1\r\n",
+			numBytesPerRead: 64,
+		}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+		// Only what was actually read is buffered; the rest is still
+		// waiting in the reader.
+		require.NotEmpty(t, r.Buffered())
+		assert.True(t, strings.HasPrefix("GET /next HTTP/1.1\r\n", string(r.Buffered())))
+	})
+
+	t.Run("Nothing buffered", func(t *testing.T) {
+		reader := &chunkReader{
+			data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.Empty(t, r.Buffered())
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8", "::1")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		remote string
+		header string
+		want   string
+	}{
+		{"Untrusted peer ignores headers", "203.0.113.7:5000", "X-Forwarded-For: 1.2.3.4", "203.0.113.7"},
+		{"No forwarding header", "10.0.0.1:5000", "", "10.0.0.1"},
+		{"X-Forwarded-For", "10.0.0.1:5000", "X-Forwarded-For: 1.2.3.4", "1.2.3.4"},
+		{"Skips trusted hops", "10.0.0.1:5000", "X-Forwarded-For: 1.2.3.4, 198.51.100.9, 10.0.0.2", "198.51.100.9"},
+		{"All hops trusted", "10.0.0.1:5000", "X-Forwarded-For: 10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"Garbage hop stops the walk", "10.0.0.1:5000", "X-Forwarded-For: 1.2.3.4, garbage, 10.0.0.2", "10.0.0.2"},
+		{"Forwarded preferred", "10.0.0.1:5000", "Forwarded: for=192.0.2.60;proto=http\r\nX-Forwarded-For: 1.2.3.4", "192.0.2.60"},
+		{"Forwarded IPv6 with port", "[::1]:5000", `Forwarded: for=192.0.2.1, for="[2001:db8::17]:4711"`, "2001:db8::17"},
+		{"Forwarded obfuscated hop", "10.0.0.1:5000", "Forwarded: for=192.0.2.1, for=_hidden", "10.0.0.1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := "GET / HTTP/1.1\r\nHost: x\r\n"
+			if tc.header != "" {
+				raw += tc.header + "\r\n"
+			}
+			r, err := RequestFromReader(strings.NewReader(raw + "\r\n"))
+			require.NoError(t, err)
+			r.RemoteAddr = net.TCPAddrFromAddrPort(netip.MustParseAddrPort(tc.remote))
+			assert.Equal(t, netip.MustParseAddr(tc.want), r.ClientIP(trusted))
+		})
+	}
+
+	t.Run("Unix socket peer", func(t *testing.T) {
+		r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\nX-Forwarded-For: 1.2.3.4\r\n\r\n"))
+		require.NoError(t, err)
+		r.RemoteAddr = &net.UnixAddr{Name: "@", Net: "unix"}
+		assert.False(t, r.ClientIP(nil).IsValid(), "not trusted by default")
+
+		trusted, err := ParseTrustedProxies("10.0.0.0/8", "unix")
+		require.NoError(t, err)
+		assert.Equal(t, netip.MustParseAddr("1.2.3.4"), r.ClientIP(trusted))
+
+		r.Headers.Del("X-Forwarded-For")
+		assert.False(t, r.ClientIP(trusted).IsValid())
+	})
+
+	t.Run("Invalid trusted proxy", func(t *testing.T) {
+		_, err := ParseTrustedProxies("10.0.0.0/33")
+		assert.Error(t, err)
+	})
+}
+
+func TestCookies(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\nCookie: theme=dark; session=\"abc\"; theme=light\r\n\r\n"))
+	require.NoError(t, err)
+
+	assert.Len(t, r.Cookies(), 3)
+	c, err := r.Cookie("theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", c.Value)
+	c, err = r.Cookie("session")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", c.Value)
+	_, err = r.Cookie("missing")
+	assert.ErrorIs(t, err, ErrNoCookie)
+}
+
+func TestCookiesOnSeveralLines(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\nCookie: theme=dark\r\nCookie: session=abc; lang=en\r\n\r\n"))
+	require.NoError(t, err)
+
+	cookies := r.Cookies()
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "theme", cookies[0].Name)
+	c, err := r.Cookie("session")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", c.Value)
+	c, err = r.Cookie("lang")
+	require.NoError(t, err)
+	assert.Equal(t, "en", c.Value)
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{MaxHeaderBytes: 64, MaxBodyBytes: 8}
+
+	t.Run("Within limits", func(t *testing.T) {
+		r, err := RequestFromReaderWithLimits(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 8\r\n\r\n12345678"), limits)
+		require.NoError(t, err)
+		assert.Equal(t, "12345678", string(r.Body))
+	})
+
+	t.Run("Header section too large", func(t *testing.T) {
+		raw := "GET / HTTP/1.1\r\nX-Filler: " + strings.Repeat("a", 64) + "\r\n\r\n"
+		_, err := RequestFromReaderWithLimits(strings.NewReader(raw), limits)
+		assert.ErrorIs(t, err, ErrHeaderTooLarge)
+	})
+
+	t.Run("Endless request line", func(t *testing.T) {
+		// The parser must give up without waiting for the line to end.
+		endless := io.MultiReader(strings.NewReader("GET /"), neverEnding('a'))
+		_, err := RequestFromReaderWithLimits(endless, limits)
+		assert.ErrorIs(t, err, ErrHeaderTooLarge)
+	})
+
+	t.Run("Body too large", func(t *testing.T) {
+		_, err := RequestFromReaderWithLimits(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\n"), limits)
+		assert.ErrorIs(t, err, ErrBodyTooLarge)
+	})
+}
+
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+func TestParseErrorKinds(t *testing.T) {
+	cases := map[string]error{
+		"GET /\r\n\r\n":                                  ErrMalformedRequestLine,
+		"GET / HTTP/1.0\r\n\r\n":                         ErrUnsupportedVersion,
+		"get / HTTP/1.1\r\n\r\n":                         ErrInvalidMethod,
+		"GET * HTTP/1.1\r\n\r\n":                         ErrInvalidTarget,
+		"GET / HTTP/1.1\r\nBad Key: x\r\n\r\n":           ErrMalformedHeader,
+		"POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n":  ErrInvalidContentLength,
+		"POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nab": ErrIncomplete,
+	}
+	for raw, want := range cases {
+		_, err := RequestFromReader(strings.NewReader(raw))
+		assert.ErrorIs(t, err, want, "%q", raw)
+	}
+}
+
+func formRequest(t *testing.T, contentType, body string) *Request {
+	t.Helper()
+	raw := fmt.Sprintf("POST /submit?lang=en&q=query HTTP/1.1\r\nHost: x\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+func TestParseFormURLEncoded(t *testing.T) {
+	r := formRequest(t, "application/x-www-form-urlencoded", "q=hello+world&tag=a&tag=b%21")
+	form, err := r.ParseForm(FormLimits{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello world"}, form.Values["q"])
+	assert.Equal(t, []string{"a", "b!"}, form.Values["tag"])
+	assert.Empty(t, form.Files)
+
+	assert.Equal(t, "hello world", r.FormValue("q"))
+	assert.Equal(t, "en", r.FormValue("lang"), "falls back to the query string")
+	assert.Equal(t, "query", r.Query().Get("q"))
+
+	_, err = formRequest(t, "application/x-www-form-urlencoded", "a=%zz").ParseForm(FormLimits{})
+	assert.ErrorIs(t, err, ErrMalformedForm)
+	_, err = formRequest(t, "application/x-www-form-urlencoded", "a=1&b=2&c=3").ParseForm(FormLimits{MaxParts: 2})
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	_, err = formRequest(t, "application/json", "{}").ParseForm(FormLimits{})
+	assert.ErrorIs(t, err, ErrNotForm)
+	assert.Equal(t, "en", formRequest(t, "application/json", "{}").FormValue("lang"))
+}
+
+const multipartBody = "preamble\r\n" +
+	"--XyZ\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"Holiday\r\n" +
+	"--XyZ \t\r\n" +
+	"Content-Disposition: form-data; name=\"photos\"; filename=\"C:\\\\pics\\\\beach.jpg\"\r\n" +
+	"Content-Type: image/jpeg\r\n" +
+	"\r\n" +
+	"small\r\n" +
+	"--XyZ\r\n" +
+	"Content-Disposition: form-data; name=\"photos\"; filename=\"../../etc/big.bin\"\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"\r\n" +
+	"0123456789\r\n--not-the-boundary\r\n" +
+	"--XyZ\r\n" +
+	"Content-Disposition: form-data; name=\"empty\"; filename=\"\"\r\n" +
+	"\r\n" +
+	"\r\n" +
+	"--XyZ--\r\n" +
+	"epilogue"
+
+func TestParseFormMultipart(t *testing.T) {
+	r := formRequest(t, `multipart/form-data; boundary="XyZ"`, multipartBody)
+	copied := r.WithContext(context.Background())
+	form, err := copied.ParseForm(FormLimits{MaxMemory: 8})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Holiday"}, form.Values["title"])
+	assert.Equal(t, []string{""}, form.Values["empty"])
+	photos := form.Files["photos"]
+	require.Len(t, photos, 2)
+
+	assert.Equal(t, "beach.jpg", photos[0].Filename)
+	assert.Equal(t, "image/jpeg", photos[0].Header.Get("Content-Type"))
+	assert.EqualValues(t, 5, photos[0].Size)
+	assert.Empty(t, photos[0].tmpFile, "fits in memory")
+
+	big := photos[1]
+	assert.Equal(t, "big.bin", big.Filename)
+	require.NotEmpty(t, big.tmpFile, "spilled to disk")
+	f, err := big.Open()
+	require.NoError(t, err)
+	content, err := io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789\r\n--not-the-boundary", string(content))
+
+	f, err = photos[0].Open()
+	require.NoError(t, err)
+	content, _ = io.ReadAll(f)
+	assert.Equal(t, "small", string(content))
+
+	// The original request shares the parsed form and removes its files.
+	again, err := r.ParseForm(FormLimits{})
+	require.NoError(t, err)
+	assert.Same(t, form, again)
+	require.NoError(t, r.RemoveFormFiles())
+	_, err = os.Stat(big.tmpFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, form.RemoveAll(), "already removed")
+}
+
+func TestFormRemoveAll(t *testing.T) {
+	r := formRequest(t, `multipart/form-data; boundary="XyZ"`, multipartBody)
+	form, err := r.ParseForm(FormLimits{MaxMemory: 1})
+	require.NoError(t, err)
+	photos := form.Files["photos"]
+	require.Len(t, photos, 2)
+	for _, fh := range photos {
+		require.NotEmpty(t, fh.tmpFile, "spilled to disk")
+	}
+
+	require.NoError(t, form.RemoveAll())
+	for _, fh := range photos {
+		_, err := os.Stat(fh.tmpFile)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+}
+
+func TestParseFormMultipartErrors(t *testing.T) {
+	part := func(headers, content string) string {
+		return "--b\r\n" + headers + "\r\n" + content + "\r\n"
+	}
+	field := part("Content-Disposition: form-data; name=\"a\"\r\n", "1")
+	file := part("Content-Disposition: form-data; name=\"f\"; filename=\"x\"\r\n", "0123456789")
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		limits      FormLimits
+		want        error
+	}{
+		{"no boundary parameter", "multipart/form-data", field + "--b--", FormLimits{}, ErrMalformedForm},
+		{"invalid boundary", "multipart/form-data; boundary=\"a\\\\b\"", field + "--b--", FormLimits{}, ErrMalformedForm},
+		{"boundary missing", "multipart/form-data; boundary=c", field + "--b--", FormLimits{}, ErrMalformedForm},
+		{"unterminated", "multipart/form-data; boundary=b", field, FormLimits{}, ErrMalformedForm},
+		{"garbage after boundary", "multipart/form-data; boundary=b", "--bx\r\n" + field + "--b--", FormLimits{}, ErrMalformedForm},
+		{"bad part header", "multipart/form-data; boundary=b", part("no colon\r\n", "x") + "--b--", FormLimits{}, ErrMalformedForm},
+		{"too many parts", "multipart/form-data; boundary=b", field + field + field + "--b--", FormLimits{MaxParts: 2}, ErrFormTooLarge},
+		{"file too large", "multipart/form-data; boundary=b", file + "--b--", FormLimits{MaxFileSize: 9}, ErrFormTooLarge},
+		{"part header too large", "multipart/form-data; boundary=b", part("X-Long: "+strings.Repeat("a", 64)+"\r\n", "x") + "--b--", FormLimits{MaxPartHeaderBytes: 32}, ErrFormTooLarge},
+	}
+	for _, tt := range tests {
+		_, err := formRequest(t, tt.contentType, tt.body).ParseForm(tt.limits)
+		assert.ErrorIs(t, err, tt.want, tt.name)
+	}
+
+	// Parts that are not form fields are skipped.
+	form, err := formRequest(t, "multipart/form-data; boundary=b",
+		part("Content-Disposition: attachment; name=\"a\"\r\n", "1")+part("", "2")+field+"--b--").ParseForm(FormLimits{})
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{"a": {"1"}}, form.Values)
+}
@@ -0,0 +1,82 @@
+package response
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+)
+
+// chunkWriter frames everything written to it as HTTP/1.1 chunks
+// (RFC 9112 7.1). Empty writes are dropped because a zero-length chunk
+// would terminate the body.
+type chunkWriter struct {
+	w io.Writer
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(cw.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := cw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := io.WriteString(cw.w, "\r\n"); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// WriteChunkedBody writes p as part of a chunked body. The headers must have
+// declared "Transfer-Encoding: chunked".
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.headersWritten && !w.chunked {
+		return 0, fmt.Errorf("headers did not declare a chunked body")
+	}
+	return w.WriteBody(p)
+}
+
+// WriteChunkedBodyDone ends a chunked body with the last chunk and no
+// trailers.
+func (w *Writer) WriteChunkedBodyDone() error {
+	return w.WriteTrailers(nil)
+}
+
+// WriteTrailers ends a chunked body with the last chunk followed by the
+// trailer fields in h. The headers should have announced them via "Trailer".
+func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if !w.headersWritten {
+		return fmt.Errorf("must write headers before ending the body")
+	}
+	if !w.chunked {
+		return fmt.Errorf("headers did not declare a chunked body")
+	}
+	if w.bodyDone {
+		return fmt.Errorf("body already finished")
+	}
+	w.bodyDone = true
+
+	if err := w.closeFilters(); err != nil {
+		return err
+	}
+	if w.rec != nil {
+		return w.rec.endBody(h)
+	}
+
+	if _, err := io.WriteString(w.wire, "0\r\n"); err != nil {
+		return err
+	}
+	for key, value := range h {
+		if _, err := fmt.Fprintf(w.wire, "%s: %s\r\n", key, value); err != nil {
+			return fmt.Errorf("error writing trailer '%s': %w", key, err)
+		}
+	}
+	_, err := io.WriteString(w.wire, "\r\n")
+	return err
+}
@@ -0,0 +1,165 @@
+package response
+
+import (
+	"bytes"
+	"httpfromtcp/internal/headers"
+	"strings"
+)
+
+// Recorder holds back a response so that middleware can inspect and change
+// it before it is sent. A handler writes to Writer as usual; the status,
+// headers (after the Writer's own OnWriteHeaders hooks and body filters)
+// and body are kept until Commit sends them to the destination Writer.
+//
+// A response that outgrows the limit, or whose handler calls Flush or
+// Hijack, cannot be held back: it is committed unchanged and the rest is
+// streamed to the destination. Hijack sends what was written so far, such
+// as a 101 response, before handing over the connection.
+type Recorder struct {
+	// StatusCode, Header, Body and Trailers hold what the handler wrote.
+	// They may be changed before Commit. Header is nil until the handler
+	// writes the headers.
+	StatusCode StatusCode
+	Header     headers.Headers
+	Body       bytes.Buffer
+	Trailers   headers.Headers
+
+	dst       *Writer
+	w         *Writer
+	limit     int
+	cookies   []string
+	bodyDone  bool
+	committed bool
+	discard   bool
+}
+
+// NewRecorder returns a Recorder for a response bound for dst that holds
+// back at most limit body bytes; zero means no limit.
+func NewRecorder(dst *Writer, limit int) *Recorder {
+	r := &Recorder{dst: dst, limit: limit}
+	r.w = &Writer{conn: recorderBody{r}, rec: r}
+	return r
+}
+
+// Writer returns the Writer the handler should write to.
+func (r *Recorder) Writer() *Writer {
+	return r.w
+}
+
+// DiscardBody makes the Recorder keep the body but never send it, as for a
+// response to HEAD: the destination gets the status line and headers only.
+// A chunked response loses its Transfer-Encoding and Trailer fields, since
+// no chunks follow.
+func (r *Recorder) DiscardBody() {
+	r.discard = true
+}
+
+// Committed reports whether the response has gone to the destination, or
+// started to, so that it can no longer be changed.
+func (r *Recorder) Committed() bool {
+	return r.committed
+}
+
+// Commit sends the recorded response to the destination: the status line
+// and headers, if the handler wrote them, with StatusCode and Header as
+// they are now, followed by Body and, for a finished chunked body,
+// Trailers. Later writes to Writer go straight to the destination.
+func (r *Recorder) Commit() error {
+	if r.committed {
+		return nil
+	}
+	r.committed = true
+	if !r.w.statusWritten {
+		return nil
+	}
+	if err := r.dst.WriteStatusLine(r.StatusCode); err != nil {
+		return err
+	}
+	if !r.w.headersWritten {
+		return nil
+	}
+	if err := r.sendHeaders(r.Header, r.cookies); err != nil {
+		return err
+	}
+	if r.discard {
+		return nil
+	}
+	if r.Body.Len() > 0 {
+		if _, err := r.dst.WriteBody(r.Body.Bytes()); err != nil {
+			return err
+		}
+		r.Body.Reset()
+	}
+	if r.bodyDone && r.dst.chunked {
+		return r.dst.WriteTrailers(r.Trailers)
+	}
+	return nil
+}
+
+func (r *Recorder) writeStatusLine(statusCode StatusCode) error {
+	if r.committed {
+		return r.dst.WriteStatusLine(statusCode)
+	}
+	r.StatusCode = statusCode
+	return nil
+}
+
+func (r *Recorder) writeHeaders(h headers.Headers, cookies []string) error {
+	if r.committed {
+		return r.sendHeaders(h, cookies)
+	}
+	r.Header = h.Clone()
+	r.cookies = cookies
+	return nil
+}
+
+func (r *Recorder) sendHeaders(h headers.Headers, cookies []string) error {
+	if r.discard && strings.EqualFold(h.Get("Transfer-Encoding"), "chunked") {
+		h = h.Clone()
+		h.Del("Transfer-Encoding")
+		h.Del("Trailer")
+	}
+	r.dst.cookies = append(r.dst.cookies, cookies...)
+	return r.dst.WriteHeaders(h)
+}
+
+func (r *Recorder) flush() error {
+	if err := r.Commit(); err != nil {
+		return err
+	}
+	return r.dst.Flush()
+}
+
+func (r *Recorder) endBody(trailers headers.Headers) error {
+	if r.committed {
+		if r.discard {
+			return nil
+		}
+		return r.dst.WriteTrailers(trailers)
+	}
+	r.Trailers = trailers
+	r.bodyDone = true
+	return nil
+}
+
+// recorderBody receives the body written to a Recorder's Writer.
+type recorderBody struct {
+	r *Recorder
+}
+
+func (b recorderBody) Write(p []byte) (int, error) {
+	r := b.r
+	if r.committed {
+		if r.discard {
+			return len(p), nil
+		}
+		return r.dst.WriteBody(p)
+	}
+	r.Body.Write(p)
+	if r.limit > 0 && r.Body.Len() > r.limit {
+		if err := r.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,144 @@
+package response
+
+import (
+	"bytes"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFixed(t *testing.T, w *Writer, body string) {
+	t.Helper()
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	h.Set("Content-Length", "5")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte(body))
+	require.NoError(t, err)
+}
+
+func TestRecorderHoldsResponse(t *testing.T) {
+	var buf bytes.Buffer
+	dst := NewWriter(&buf)
+	rec := NewRecorder(dst, 0)
+	w := rec.Writer()
+	w.OnWriteHeaders(func(_ StatusCode, h headers.Headers) { h.Set("X-Hook", "1") })
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "b"}))
+	writeFixed(t, w, "hello")
+	require.NoError(t, w.Finish())
+
+	assert.Zero(t, buf.Len(), "nothing is sent before Commit")
+	assert.False(t, rec.Committed())
+	assert.Equal(t, StatusOK, rec.StatusCode)
+	assert.Equal(t, "1", rec.Header.Get("X-Hook"))
+	assert.Equal(t, "hello", rec.Body.String())
+
+	rec.StatusCode = StatusNotFound
+	rec.Header.Set("Content-Length", "4")
+	rec.Body.Reset()
+	rec.Body.WriteString("gone")
+	require.NoError(t, rec.Commit())
+	require.NoError(t, dst.Finish())
+
+	raw := buf.String()
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 404 Not Found\r\n"))
+	assert.Contains(t, raw, "x-hook: 1\r\n")
+	assert.Contains(t, raw, "set-cookie: a=b\r\n")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\ngone"))
+	assert.Equal(t, int64(4), dst.BytesWritten())
+}
+
+func TestRecorderStreamsPastLimit(t *testing.T) {
+	var buf bytes.Buffer
+	dst := NewWriter(&buf)
+	rec := NewRecorder(dst, 3)
+	w := rec.Writer()
+	writeFixed(t, w, "hello")
+
+	assert.True(t, rec.Committed())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+}
+
+func TestRecorderChunked(t *testing.T) {
+	chunked := func(w *Writer, flush bool) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hel"))
+		if flush {
+			w.Flush()
+		}
+		w.WriteChunkedBody([]byte("lo"))
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Sum", "1")
+		w.WriteTrailers(trailers)
+	}
+
+	// The recorded body is unframed and goes out as a single chunk.
+	var buf bytes.Buffer
+	rec := NewRecorder(NewWriter(&buf), 0)
+	chunked(rec.Writer(), false)
+	assert.Equal(t, "hello", rec.Body.String())
+	assert.Equal(t, "1", rec.Trailers.Get("X-Sum"))
+	require.NoError(t, rec.Commit())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n5\r\nhello\r\n0\r\nx-sum: 1\r\n\r\n"), buf.String())
+
+	// Flush commits; the rest is framed by the destination.
+	buf.Reset()
+	rec = NewRecorder(NewWriter(&buf), 0)
+	chunked(rec.Writer(), true)
+	assert.True(t, rec.Committed())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n3\r\nhel\r\n2\r\nlo\r\n0\r\nx-sum: 1\r\n\r\n"), buf.String())
+}
+
+func TestRecorderDiscardBody(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(NewWriter(&buf), 0)
+	rec.DiscardBody()
+	writeFixed(t, rec.Writer(), "hello")
+	assert.Equal(t, "hello", rec.Body.String(), "the body is still recorded")
+	require.NoError(t, rec.Commit())
+	assert.Contains(t, buf.String(), "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.NotContains(t, buf.String(), "hello")
+
+	// Nor is a streamed body sent, chunked or not.
+	buf.Reset()
+	dst := NewWriter(&buf)
+	rec = NewRecorder(dst, 0)
+	rec.DiscardBody()
+	w := rec.Writer()
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Sum")
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(h)
+	require.NoError(t, w.Flush())
+	w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, w.Finish())
+	require.NoError(t, dst.Finish())
+
+	raw := buf.String()
+	assert.True(t, strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n"))
+	assert.NotContains(t, raw, "transfer-encoding")
+	assert.NotContains(t, raw, "trailer")
+	assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"), raw)
+}
+
+func TestRecorderCommitBeforeHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(NewWriter(&buf), 0)
+	require.NoError(t, rec.Commit())
+	assert.Zero(t, buf.Len())
+
+	// Once committed, the handler writes straight through.
+	writeFixed(t, rec.Writer(), "hello")
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+}
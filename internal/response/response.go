@@ -1,40 +1,208 @@
 package response
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/cookie"
 	"httpfromtcp/internal/headers" // Import headers
 	"io"
+	"net"
+	"strconv"
+	"strings"
 )
 
 type StatusCode int
 
 const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	StatusSwitchingProtocols           StatusCode = 101
+	StatusOK                           StatusCode = 200
+	StatusNoContent                    StatusCode = 204
+	StatusPartialContent               StatusCode = 206
+	StatusMovedPermanently             StatusCode = 301
+	StatusNotModified                  StatusCode = 304
+	StatusBadRequest                   StatusCode = 400
+	StatusUnauthorized                 StatusCode = 401
+	StatusForbidden                    StatusCode = 403
+	StatusNotFound                     StatusCode = 404
+	StatusMethodNotAllowed             StatusCode = 405
+	StatusNotAcceptable                StatusCode = 406
+	StatusProxyAuthRequired            StatusCode = 407
+	StatusRequestTimeout               StatusCode = 408
+	StatusPreconditionFailed           StatusCode = 412
+	StatusContentTooLarge              StatusCode = 413
+	StatusUnsupportedMediaType         StatusCode = 415
+	StatusRequestedRangeNotSatisfiable StatusCode = 416
+	StatusUpgradeRequired              StatusCode = 426
+	StatusTooManyRequests              StatusCode = 429
+	StatusRequestHeaderFieldsTooLarge  StatusCode = 431
+	StatusInternalServerError          StatusCode = 500
+	StatusBadGateway                   StatusCode = 502
+	StatusServiceUnavailable           StatusCode = 503
+	StatusGatewayTimeout               StatusCode = 504
 )
 
 var reasonPhrases = map[StatusCode]string{
-	StatusOK:                  "OK",
-	StatusBadRequest:          "Bad Request",
-	StatusInternalServerError: "Internal Server Error",
+	StatusSwitchingProtocols:           "Switching Protocols",
+	StatusOK:                           "OK",
+	StatusNoContent:                    "No Content",
+	StatusPartialContent:               "Partial Content",
+	StatusMovedPermanently:             "Moved Permanently",
+	StatusNotModified:                  "Not Modified",
+	StatusBadRequest:                   "Bad Request",
+	StatusUnauthorized:                 "Unauthorized",
+	StatusForbidden:                    "Forbidden",
+	StatusNotFound:                     "Not Found",
+	StatusMethodNotAllowed:             "Method Not Allowed",
+	StatusNotAcceptable:                "Not Acceptable",
+	StatusProxyAuthRequired:            "Proxy Authentication Required",
+	StatusRequestTimeout:               "Request Timeout",
+	StatusPreconditionFailed:           "Precondition Failed",
+	StatusContentTooLarge:              "Content Too Large",
+	StatusUnsupportedMediaType:         "Unsupported Media Type",
+	StatusRequestedRangeNotSatisfiable: "Range Not Satisfiable",
+	StatusUpgradeRequired:              "Upgrade Required",
+	StatusTooManyRequests:              "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge:  "Request Header Fields Too Large",
+	StatusInternalServerError:          "Internal Server Error",
+	StatusBadGateway:                   "Bad Gateway",
+	StatusServiceUnavailable:           "Service Unavailable",
+	StatusGatewayTimeout:               "Gateway Timeout",
+}
+
+// StatusText returns the reason phrase for statusCode, or an empty string if
+// the code is unknown.
+func StatusText(statusCode StatusCode) string {
+	return reasonPhrases[statusCode]
 }
 
 type Writer struct {
 	conn           io.Writer
 	statusWritten  bool
 	headersWritten bool
+	statusCode     StatusCode
+
+	// body is where WriteBody sends data: payload itself, or the outermost
+	// of the filters installed through WrapBody layered over it. payload
+	// counts the body after the filters and feeds the chunk framing, if
+	// any, which writes to wire; wire counts what reaches conn after the
+	// headers.
+	body        io.Writer
+	payload     *countingWriter
+	wire        *countingWriter
+	filters     []io.WriteCloser
+	wrappers    []func(io.Writer) io.WriteCloser
+	headerHooks []func(statusCode StatusCode, h headers.Headers)
+	cookies     []string
+	hijackHooks []func()
+	finishHooks []func()
+	chunked     bool
+	bodyDone    bool
+	hijacked    bool
+	buffered    []byte
+
+	// rec is set for the Writer of a Recorder, which keeps the response
+	// instead of sending it.
+	rec *Recorder
 }
 
+// ErrHijacked is returned by Writer methods once the connection has been
+// taken over with Hijack.
+var ErrHijacked = errors.New("connection has been hijacked")
+
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{conn: w}
 }
 
+// StatusCode returns the status code passed to WriteStatusLine, or 0 if no
+// status line has been written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns the number of bytes sent after the header section:
+// the body as it went out on the wire, after any filters and including chunk
+// framing and trailers.
+func (w *Writer) BytesWritten() int64 {
+	if w.wire == nil {
+		return 0
+	}
+	return w.wire.n
+}
+
+// BodyBytesWritten returns the size of the body sent so far, after any
+// filters such as compression but before chunk framing: the figure an
+// access log reports.
+func (w *Writer) BodyBytesWritten() int64 {
+	if w.payload == nil {
+		return 0
+	}
+	return w.payload.n
+}
+
+// OnWriteHeaders registers fn to run when WriteHeaders is called, before
+// anything is sent. fn may modify h and may call WrapBody; this is how
+// middleware adjusts a response it did not produce.
+func (w *Writer) OnWriteHeaders(fn func(statusCode StatusCode, h headers.Headers)) {
+	w.headerHooks = append(w.headerHooks, fn)
+}
+
+// SetCookie adds a Set-Cookie header for c to the response. Each cookie is
+// sent on a line of its own, since Set-Cookie values cannot be combined
+// into a comma-separated list. It must be called before the headers are
+// written, possibly from an OnWriteHeaders hook, and fails if c is not
+// valid.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.headersWritten {
+		return fmt.Errorf("cannot set cookie %s: headers already written", c.Name)
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c.String())
+	return nil
+}
+
+// AddSetCookie adds a Set-Cookie header with value as it is, for relaying a
+// cookie set by another server. Like SetCookie it must be called before the
+// headers are written.
+func (w *Writer) AddSetCookie(value string) error {
+	if w.headersWritten {
+		return fmt.Errorf("cannot add set-cookie: headers already written")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("invalid set-cookie value %q", value)
+	}
+	w.cookies = append(w.cookies, value)
+	return nil
+}
+
+// WrapBody installs a filter on the body stream. wrap receives the writer
+// the body currently flows into and returns one that feeds it. It must be
+// called before the headers are written, typically from an OnWriteHeaders
+// hook. Filters are closed when the body is finished.
+func (w *Writer) WrapBody(wrap func(io.Writer) io.WriteCloser) {
+	w.wrappers = append(w.wrappers, wrap)
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if w.hijacked {
+		return ErrHijacked
+	}
 	if w.statusWritten {
 		return fmt.Errorf("status line already written")
 	}
 
+	if w.rec != nil {
+		if err := w.rec.writeStatusLine(statusCode); err != nil {
+			return err
+		}
+		w.statusWritten = true
+		w.statusCode = statusCode
+		return nil
+	}
+
 	reasonPhrase, ok := reasonPhrases[statusCode]
 	if !ok {
 		reasonPhrase = ""
@@ -44,11 +212,18 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	_, err := w.conn.Write([]byte(statusLine))
 	if err == nil {
 		w.statusWritten = true
+		w.statusCode = statusCode
 	}
 	return err
 }
 
+// WriteHeaders writes h followed by the blank line that ends the header
+// section. If h declares "Transfer-Encoding: chunked" (possibly added by an
+// OnWriteHeaders hook) the body is framed as chunks from then on.
 func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if w.hijacked {
+		return ErrHijacked
+	}
 	if !w.statusWritten {
 		return fmt.Errorf("must write status line before writing headers")
 	}
@@ -56,6 +231,23 @@ func (w *Writer) WriteHeaders(h headers.Headers) error {
 		return fmt.Errorf("headers already written")
 	}
 
+	if len(w.headerHooks) > 0 {
+		h = h.Clone()
+		for _, hook := range w.headerHooks {
+			hook(w.statusCode, h)
+		}
+	}
+
+	if w.rec != nil {
+		if err := w.rec.writeHeaders(h, w.cookies); err != nil {
+			return err
+		}
+		w.headersWritten = true
+		w.chunked = strings.EqualFold(h.Get("Transfer-Encoding"), "chunked")
+		w.setupBody()
+		return nil
+	}
+
 	for key, value := range h {
 		headerLine := fmt.Sprintf("%s: %s\r\n", key, value)
 		_, err := w.conn.Write([]byte(headerLine))
@@ -63,19 +255,212 @@ func (w *Writer) WriteHeaders(h headers.Headers) error {
 			return fmt.Errorf("error writing header '%s': %w", key, err)
 		}
 	}
+	for _, c := range w.cookies {
+		if _, err := w.conn.Write([]byte("set-cookie: " + c + "\r\n")); err != nil {
+			return fmt.Errorf("error writing header 'set-cookie': %w", err)
+		}
+	}
 
 	_, err := w.conn.Write([]byte("\r\n"))
 	if err == nil {
 		w.headersWritten = true
+		w.chunked = strings.EqualFold(h.Get("Transfer-Encoding"), "chunked")
+		w.setupBody()
 	}
 	return err
 }
 
+func (w *Writer) setupBody() {
+	w.wire = &countingWriter{w: w.conn}
+	var framed io.Writer = w.wire
+	if w.chunked && w.rec == nil {
+		framed = &chunkWriter{w: w.wire}
+	}
+	w.payload = &countingWriter{w: framed}
+	w.body = w.payload
+	for _, wrap := range w.wrappers {
+		filter := wrap(w.body)
+		w.filters = append(w.filters, filter)
+		w.body = filter
+	}
+}
+
 func (w *Writer) WriteBody(body []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
 	if !w.headersWritten {
 		return 0, fmt.Errorf("must write headers (including blank line) before writing body")
 	}
+	if w.bodyDone {
+		return 0, fmt.Errorf("body already finished")
+	}
+
+	n, err := w.body.Write(body)
+	return n, err
+}
+
+// Write implements io.Writer by forwarding to WriteBody, so a Writer can be
+// used as the destination of io.Copy and friends.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+// Flush pushes out body data held back by filters, such as a compressor's
+// pending block, so that streamed output reaches the client promptly.
+func (w *Writer) Flush() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	for i := len(w.filters) - 1; i >= 0; i-- {
+		if f, ok := w.filters[i].(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if w.rec != nil {
+		return w.rec.flush()
+	}
+	return nil
+}
+
+// WriteError sends a complete response for statusCode with a short
+// plain-text body naming the status. Any headers in h are sent as well. A
+// status that cannot have a body, such as 304 Not Modified, gets the status
+// line and headers only.
+func (w *Writer) WriteError(statusCode StatusCode, h headers.Headers) error {
+	h = h.Clone()
+	var body string
+	if !bodyless(statusCode) {
+		body = fmt.Sprintf("%d %s\n", statusCode, StatusText(statusCode))
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if body == "" {
+		return nil
+	}
+	_, err := w.WriteBody([]byte(body))
+	return err
+}
+
+// bodyless reports whether responses with statusCode never carry content
+// (RFC 9110 6.4.1).
+func bodyless(statusCode StatusCode) bool {
+	return statusCode < 200 || statusCode == StatusNoContent || statusCode == StatusNotModified
+}
+
+// Finish completes the response body: it closes any body filters and, for a
+// chunked body that has not been terminated yet, writes the last chunk. It is
+// safe to call more than once, and the server calls it after every handler.
+func (w *Writer) Finish() error {
+	hooks := w.finishHooks
+	w.finishHooks = nil
+	for _, fn := range hooks {
+		fn()
+	}
+	if w.hijacked || !w.headersWritten || w.bodyDone {
+		return nil
+	}
+	if w.chunked {
+		return w.WriteChunkedBodyDone()
+	}
+	w.bodyDone = true
+	return w.closeFilters()
+}
+
+func (w *Writer) closeFilters() error {
+	var firstErr error
+	for i := len(w.filters) - 1; i >= 0; i-- {
+		if err := w.filters[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	w.filters = nil
+	return firstErr
+}
+
+// OnFinish registers fn to run at the start of Finish, once the handler is
+// done with the response. Something that keeps writing to the body from
+// another goroutine uses it to stop, and to end the body itself, before
+// Finish touches the connection.
+func (w *Writer) OnFinish(fn func()) {
+	w.finishHooks = append(w.finishHooks, fn)
+}
+
+// SetBuffered records bytes the server has already read from the
+// connection but not parsed, so that Hijack can hand them to the new owner.
+func (w *Writer) SetBuffered(b []byte) {
+	w.buffered = b
+}
+
+// OnHijack registers fn to run at the start of a successful Hijack. The
+// server uses it to stop watching the connection and to hand over, through
+// SetBuffered, any input it read in the meantime.
+func (w *Writer) OnHijack(fn func()) {
+	w.hijackHooks = append(w.hijackHooks, fn)
+}
+
+// Hijack hands the underlying connection over to the caller, for protocols
+// such as WebSocket or CONNECT tunnels that take over after an HTTP
+// exchange. The returned ReadWriter's reader yields any bytes the server had
+// already read past the request before reading from the connection; its
+// writer must be flushed by the caller. Afterwards the Writer refuses
+// further writes and the server neither finishes the response nor closes or
+// reuses the connection; both become the caller's job.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.rec != nil {
+		// The new owner of the connection expects the response head, such
+		// as a 101 Switching Protocols, to have gone out already.
+		if err := w.rec.Commit(); err != nil {
+			return nil, nil, err
+		}
+		conn, rw, err := w.rec.dst.Hijack()
+		if err == nil {
+			w.hijacked = true
+		}
+		return conn, rw, err
+	}
+	conn, ok := w.conn.(net.Conn)
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot hijack: %T is not a net.Conn", w.conn)
+	}
+	w.hijacked = true
+	for _, hook := range w.hijackHooks {
+		hook()
+	}
+
+	var r io.Reader = conn
+	if len(w.buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(w.buffered), conn)
+		w.buffered = nil
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(r), bufio.NewWriter(conn))
+	return conn, rw, nil
+}
+
+// Hijacked reports whether Hijack has been called successfully.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
 
-	n, err := w.conn.Write(body)
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
 	return n, err
 }
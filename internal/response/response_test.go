@@ -0,0 +1,46 @@
+package response
+
+import (
+	"bytes"
+	"httpfromtcp/internal/headers"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	h := headers.NewHeaders()
+	h.Set("Allow", "GET")
+	require.NoError(t, NewWriter(&buf).WriteError(StatusMethodNotAllowed, h))
+	raw := buf.String()
+	assert.Regexp(t, `^HTTP/1.1 405 Method Not Allowed\r\n`, raw)
+	assert.Contains(t, raw, "allow: GET\r\n")
+	assert.Contains(t, raw, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, raw, "content-length: 23\r\n")
+	assert.Regexp(t, `\r\n\r\n405 Method Not Allowed\n$`, raw)
+	assert.Empty(t, h.Get("Content-Type"), "the caller's headers are left alone")
+
+	for _, status := range []StatusCode{StatusNoContent, StatusNotModified} {
+		buf.Reset()
+		require.NoError(t, NewWriter(&buf).WriteError(status, nil))
+		assert.NotContains(t, buf.String(), "content-")
+		assert.Regexp(t, `\r\n\r\n$`, buf.String())
+	}
+}
+
+func TestBodyBytesWritten(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteChunkedBodyDone())
+
+	assert.Equal(t, int64(5), w.BodyBytesWritten())
+	assert.Equal(t, int64(len("5\r\nhello\r\n0\r\n\r\n")), w.BytesWritten())
+}
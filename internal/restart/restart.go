@@ -0,0 +1,235 @@
+package restart
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// envListenFDs tells a child started by Start how many listening sockets
+	// it inherited. They are file descriptors 3 onwards, followed by the
+	// write end of the readiness pipe.
+	envListenFDs = "HTTPFROMTCP_LISTEN_FDS"
+
+	firstFD = 3
+
+	// DefaultReadyTimeout is how long Start waits for the child to call
+	// Ready when no timeout is given.
+	DefaultReadyTimeout = 30 * time.Second
+)
+
+var (
+	readyMu   sync.Mutex
+	readyPipe *os.File
+)
+
+// Inherit returns the listeners handed over by the parent process when this
+// process was started by Start, or nil otherwise. Once the process is
+// serving on them it must call Ready.
+func Inherit() ([]net.Listener, error) {
+	value, ok := os.LookupEnv(envListenFDs)
+	if !ok {
+		return nil, nil
+	}
+	// Our own children must not mistake the descriptors for theirs.
+	os.Unsetenv(envListenFDs)
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %s %q", envListenFDs, value)
+	}
+	listeners, pipe, err := inherit(n, firstFD)
+	if err != nil {
+		return nil, err
+	}
+	readyMu.Lock()
+	readyPipe = pipe
+	readyMu.Unlock()
+	return listeners, nil
+}
+
+func inherit(n, first int) ([]net.Listener, *os.File, error) {
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(first+i), "inherited listener")
+		// FileListener works on a duplicate, which is close-on-exec.
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, nil, fmt.Errorf("inherited fd %d: %w", first+i, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, os.NewFile(uintptr(first+n), "restart ready pipe"), nil
+}
+
+// Ready tells the parent that this process is accepting connections, so it
+// can stop accepting and drain. It does nothing if Inherit found no parent.
+func Ready() error {
+	readyMu.Lock()
+	defer readyMu.Unlock()
+	if readyPipe == nil {
+		return nil
+	}
+	_, err := readyPipe.Write([]byte{1})
+	if closeErr := readyPipe.Close(); err == nil {
+		err = closeErr
+	}
+	readyPipe = nil
+	return err
+}
+
+// Start execs a new copy of the running binary, with the same arguments and
+// environment, and hands it listeners. It returns once the child has called
+// Ready, after which the caller should shut down gracefully. If the child
+// exits or is not ready within timeout (DefaultReadyTimeout if zero), it is
+// killed and an error is returned; the caller keeps serving.
+//
+// Unix socket listeners are set not to remove their socket file when
+// closed, since the child goes on serving on it.
+func Start(timeout time.Duration, listeners ...net.Listener) (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find executable: %w", err)
+	}
+	return start(path, os.Args[1:], os.Environ(), timeout, listeners)
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+func start(path string, args, env []string, timeout time.Duration, listeners []net.Listener) (*os.Process, error) {
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, listener := range listeners {
+		l, ok := listener.(filer)
+		if !ok {
+			return nil, fmt.Errorf("cannot hand over %T: no file descriptor", listener)
+		}
+		f, err := l.File()
+		if err != nil {
+			return nil, fmt.Errorf("cannot hand over listener %s: %w", listener.Addr(), err)
+		}
+		files = append(files, f)
+	}
+
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyRead.Close()
+	files = append(files, readyWrite)
+
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(withoutListenEnv(env), envListenFDs+"="+strconv.Itoa(len(listeners)))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start %s: %w", path, err)
+	}
+	// Only the child may hold the write end now, so that reading it ends
+	// if the child dies.
+	readyWrite.Close()
+	files = files[:len(files)-1]
+
+	// Passing the descriptors made them blocking, and the mode is shared
+	// with our listeners. The child switches it back when it inherits them,
+	// but if it fails first, a blocking Accept here could not be interrupted
+	// by closing the listener.
+	for _, listener := range listeners {
+		if err := setNonblock(listener); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, fmt.Errorf("cannot restore listener %s: %w", listener.Addr(), err)
+		}
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := io.ReadFull(readyRead, b[:])
+		if errors.Is(err, io.EOF) {
+			err = errors.New("child exited before it was ready")
+		}
+		ready <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-ready:
+	case <-timer.C:
+		err = fmt.Errorf("child not ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
+	for _, listener := range listeners {
+		if ul, ok := listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	// The child outlives us; nobody waits for it here.
+	go cmd.Wait()
+	return cmd.Process, nil
+}
+
+func setNonblock(listener net.Listener) error {
+	sc, ok := listener.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	err = raw.Control(func(fd uintptr) {
+		opErr = syscall.SetNonblock(int(fd), true)
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
+// withoutListenEnv drops socket-passing variables meant for this process,
+// including systemd's, so the child only sees what Start hands it.
+func withoutListenEnv(env []string) []string {
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case envListenFDs, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES":
+			continue
+		}
+		out = append(out, kv)
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package restart
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHelperChild is not a real test: Start runs the test binary with
+// -test.run=TestHelperChild to play the replacement process.
+func TestHelperChild(t *testing.T) {
+	mode := os.Getenv("RESTART_TEST_CHILD")
+	if mode == "" {
+		t.Skip("only runs as a child process")
+	}
+	if mode == "crash" {
+		os.Exit(3)
+	}
+
+	listeners, err := Inherit()
+	if err != nil || len(listeners) != 1 {
+		os.Exit(2)
+	}
+	if err := Ready(); err != nil {
+		os.Exit(2)
+	}
+	conn, err := listeners[0].Accept()
+	if err != nil {
+		os.Exit(2)
+	}
+	conn.Write([]byte("served by child\n"))
+	conn.Close()
+	os.Exit(0)
+}
+
+func startChild(t *testing.T, mode string, listener net.Listener) (*os.Process, error) {
+	t.Helper()
+	env := append(os.Environ(), "RESTART_TEST_CHILD="+mode)
+	return start(os.Args[0], []string{"-test.run=^TestHelperChild$"}, env, 10*time.Second, []net.Listener{listener})
+}
+
+func TestStartHandsOverListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	child, err := startChild(t, "serve", listener)
+	require.NoError(t, err)
+	require.NotNil(t, child)
+
+	// The parent stops accepting; the socket stays open in the child.
+	addr := listener.Addr().String()
+	listener.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "served by child\n", line)
+}
+
+func TestStartFailsWhenChildDies(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, err = startChild(t, "crash", listener)
+	assert.ErrorContains(t, err, "exited before it was ready")
+
+	// The parent keeps serving, and closing its listener must still
+	// interrupt a pending Accept.
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if conn != nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go listener.Close()
+	select {
+	case err := <-accepted:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Accept not interrupted by Close")
+	}
+}
+
+func TestInheritWithoutParent(t *testing.T) {
+	listeners, err := Inherit()
+	assert.NoError(t, err)
+	assert.Nil(t, listeners)
+	assert.NoError(t, Ready())
+}
+
+func TestWithoutListenEnv(t *testing.T) {
+	env := withoutListenEnv([]string{"PATH=/bin", "LISTEN_PID=1", "LISTEN_FDS=1", envListenFDs + "=2", "HOME=/root"})
+	assert.Equal(t, []string{"PATH=/bin", "HOME=/root"}, env)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// UnixPrefix marks an address passed to Listen as a Unix domain socket path,
+// as in "unix:/run/app.sock".
+const UnixPrefix = "unix:"
+
+// systemdFirstFD is the first file descriptor passed by systemd socket
+// activation (SD_LISTEN_FDS_START).
+const systemdFirstFD = 3
+
+// Listen opens a listener for addr: a Unix domain socket for "unix:" paths,
+// TCP otherwise.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		return ListenUnix(path, 0)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s: %w", addr, err)
+	}
+	return listener, nil
+}
+
+// ListenUnix listens on a Unix domain socket at path. A socket file left
+// behind by a process that is gone is removed first; one that still accepts
+// connections, or any other kind of file, is left alone and reported as an
+// error. If perm is non-zero the socket's permissions are set to it, which
+// is what controls who may connect. The socket file is removed when the
+// listener is closed.
+func ListenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s%s: %w", UnixPrefix, path, err)
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("cannot set permissions on %s: %w", path, err)
+		}
+	}
+	return listener, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("cannot listen on %s%s: file exists and is not a socket", UnixPrefix, path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("cannot listen on %s%s: %w", UnixPrefix, path, syscall.EADDRINUSE)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("cannot check existing socket %s: %w", path, err)
+	}
+	return os.Remove(path)
+}
+
+// SystemdListeners returns the listening sockets passed by systemd socket
+// activation (sd_listen_fds(3)), in the order of the unit's Listen*
+// directives, or nil if the process was not socket-activated. The
+// LISTEN_* variables are removed from the environment so that child
+// processes do not pick the sockets up as well.
+func SystemdListeners() ([]net.Listener, error) {
+	listeners, err := systemdListeners(os.Getenv, os.Getpid(), systemdFirstFD)
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return listeners, err
+}
+
+func systemdListeners(getenv func(string) string, pid, firstFD int) ([]net.Listener, error) {
+	if getenv("LISTEN_PID") == "" {
+		return nil, nil
+	}
+	if listenPID, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || listenPID != pid {
+		// The sockets were meant for another process, e.g. our parent.
+		return nil, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(firstFD+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(firstFD+i), name)
+		// FileListener works on a duplicate, which is close-on-exec.
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket-activated fd %d (%s): %w", firstFD+i, name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"os"
+	"strconv"
+	"time"
+)
+
+// serverMetrics instruments a Server. A nil *serverMetrics records nothing.
+type serverMetrics struct {
+	activeConns   *metrics.Gauge
+	acceptedConns *metrics.Counter
+	closedConns   *metrics.Counter
+	rejectedConns *metrics.Counter
+	acceptErrors  *metrics.Counter
+	requests      *metrics.CounterVec
+	parseErrors   *metrics.CounterVec
+	requestBytes  *metrics.Counter
+	responseBytes *metrics.Counter
+	duration      *metrics.HistogramVec
+}
+
+func newServerMetrics(reg *metrics.Registry) *serverMetrics {
+	return &serverMetrics{
+		activeConns: reg.NewGauge("http_server_active_connections",
+			"Connections currently being served."),
+		acceptedConns: reg.NewCounter("http_server_connections_accepted_total",
+			"Connections accepted."),
+		closedConns: reg.NewCounter("http_server_connections_closed_total",
+			"Connections closed, or handed over to a handler by hijacking."),
+		rejectedConns: reg.NewCounter("http_server_connections_rejected_total",
+			"Connections turned away with 503 because the connection limit was reached."),
+		acceptErrors: reg.NewCounter("http_server_accept_errors_total",
+			"Failed attempts to accept a connection."),
+		requests: reg.NewCounterVec("http_server_requests_total",
+			"Requests handled, by method and status class.", "method", "code"),
+		parseErrors: reg.NewCounterVec("http_server_parse_errors_total",
+			"Requests rejected as malformed, by what was wrong.", "type"),
+		requestBytes: reg.NewCounter("http_server_request_body_bytes_total",
+			"Bytes of request bodies received."),
+		responseBytes: reg.NewCounter("http_server_response_body_bytes_total",
+			"Bytes of response bodies sent, after any content coding and chunk framing."),
+		duration: reg.NewHistogramVec("http_server_request_duration_seconds",
+			"Time from accepting the connection to finishing the response.", metrics.DefaultBuckets, "method"),
+	}
+}
+
+func (m *serverMetrics) connAccepted() {
+	if m == nil {
+		return
+	}
+	m.acceptedConns.Inc()
+	m.activeConns.Inc()
+}
+
+func (m *serverMetrics) connClosed() {
+	if m == nil {
+		return
+	}
+	m.closedConns.Inc()
+	m.activeConns.Dec()
+}
+
+func (m *serverMetrics) connRejected() {
+	if m == nil {
+		return
+	}
+	m.rejectedConns.Inc()
+}
+
+func (m *serverMetrics) acceptError() {
+	if m == nil {
+		return
+	}
+	m.acceptErrors.Inc()
+}
+
+func (m *serverMetrics) parseError(err error) {
+	if m == nil {
+		return
+	}
+	m.parseErrors.With(parseErrorType(err)).Inc()
+}
+
+func (m *serverMetrics) requestDone(req *request.Request, w *response.Writer, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	method := methodLabel(req.RequestLine.Method)
+	m.requests.With(method, statusClass(w.StatusCode())).Inc()
+	m.requestBytes.Add(uint64(len(req.Body)))
+	m.responseBytes.Add(uint64(w.BytesWritten()))
+	m.duration.With(method).Observe(elapsed.Seconds())
+}
+
+func parseErrorType(err error) string {
+	switch {
+	case errors.Is(err, request.ErrMalformedRequestLine):
+		return "request_line"
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return "version"
+	case errors.Is(err, request.ErrInvalidMethod):
+		return "method"
+	case errors.Is(err, request.ErrInvalidTarget):
+		return "target"
+	case errors.Is(err, request.ErrMalformedHeader):
+		return "header"
+	case errors.Is(err, request.ErrInvalidContentLength):
+		return "content_length"
+	case errors.Is(err, request.ErrIncomplete):
+		return "incomplete"
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return "header_too_large"
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return "body_too_large"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	}
+	return "io"
+}
+
+// methodLabel keeps the label set bounded: clients may send any token as a
+// method.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return method
+	}
+	return "OTHER"
+}
+
+// statusClass returns e.g. "2xx", or "none" if the handler sent no status.
+func statusClass(code response.StatusCode) string {
+	if code < 100 || code > 599 {
+		return "none"
+	}
+	return strconv.Itoa(int(code)/100) + "xx"
+}
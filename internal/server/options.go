@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/tls"
+	"httpfromtcp/internal/accesslog"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/request"
+	"log/slog"
+	"time"
+)
+
+// Option configures a Server created by Serve, ListenAndServe or
+// ServeListener.
+type Option func(*Server)
+
+// WithRequestTimeout bounds how long a handler may run: once d has elapsed
+// the request's context is cancelled with ErrRequestTimeout. Handlers are
+// expected to notice and return; the server does not interrupt them.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = d
+	}
+}
+
+// WithReadTimeout bounds how long a client may take to send its request,
+// from the moment the connection is accepted. A client that is too slow gets
+// a 408 response.
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readTimeout = d
+	}
+}
+
+// WithWriteTimeout bounds how long writing the response may take, from the
+// moment the request has been read. Writes after the deadline fail.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.writeTimeout = d
+	}
+}
+
+// WithLimits sets the request size limits. Requests over them get a 431 or
+// 413 response.
+func WithLimits(limits request.Limits) Option {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+// WithMaxConnections caps the number of connections served at once. While
+// the cap is reached the server stops accepting, so new clients wait in the
+// listen backlog, unless WithRejectOverLimit is also given.
+func WithMaxConnections(n int) Option {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// WithRejectOverLimit makes the server answer connections over the
+// WithMaxConnections limit right away with 503 Service Unavailable and a
+// Retry-After of retryAfter (rounded up to whole seconds, at least one),
+// instead of leaving them queued.
+func WithRejectOverLimit(retryAfter time.Duration) Option {
+	return func(s *Server) {
+		s.rejectOverMax = true
+		s.retryAfter = retryAfter
+	}
+}
+
+// WithTLS serves HTTPS: accepted connections are wrapped in TLS using cfg,
+// which must provide a certificate.
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithErrorHandler replaces the plain-text responses the server sends for
+// malformed requests and handler panics.
+func WithErrorHandler(fn ErrorHandler) Option {
+	return func(s *Server) {
+		s.errorHandler = fn
+	}
+}
+
+// WithAccessLog records every request, including those rejected as
+// malformed, to l once its response is complete.
+func WithAccessLog(l *accesslog.Logger) Option {
+	return func(s *Server) {
+		s.accessLog = l
+	}
+}
+
+// WithTrustedProxies sets the proxies whose forwarding headers are believed
+// when logging the client address; see request.Request.ClientIP.
+func WithTrustedProxies(trusted request.TrustedProxies) Option {
+	return func(s *Server) {
+		s.trustedProxies = trusted
+	}
+}
+
+// WithLogger sends the server's own events to logger instead of discarding
+// them. Connection and request IDs are attached as attributes, failures
+// carry a "category" attribute, and handlers can log through the same logger
+// with request.LoggerFromContext.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithMetrics registers the server's connection, request, parse error, byte
+// and latency metrics in reg. Serve them with metrics.Handler(reg). Each
+// registry can instrument only one server, as metric names must be unique.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(s *Server) {
+		s.metrics = newServerMetrics(reg)
+	}
+}
@@ -1,52 +1,206 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/accesslog"
 	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
-	"log"
+	"io"
+	"log/slog"
+	"math"
 	"net"
+	"os"
+	"runtime/debug"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Handler func(w *response.Writer, req *request.Request)
 
+const (
+	// Bounds of the exponential backoff after a failed Accept.
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+
+	// rejectTimeout bounds the time spent turning away a connection over
+	// the limit, and rejectDrainBytes how much of its request is read.
+	rejectTimeout    = time.Second
+	rejectDrainBytes = 64 << 10
+)
+
+// ErrorHandler writes the response when the server cannot hand a request to
+// the Handler, or the Handler fails: err wraps one of the request package's
+// parse errors, a read timeout, or ErrHandlerPanic. statusCode is the status
+// the server picked for it. For a panic it is only called if the handler had
+// not yet written a status line.
+type ErrorHandler func(w *response.Writer, statusCode response.StatusCode, err error)
+
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
 }
 
+var (
+	// ErrClientDisconnected is the context cause when the client hangs up
+	// while its request is being handled.
+	ErrClientDisconnected = errors.New("client disconnected")
+	// ErrServerClosed is the context cause for requests still running when
+	// the server is closed.
+	ErrServerClosed = errors.New("server closed")
+	// ErrRequestTimeout is the context cause when a request outlives the
+	// timeout set with WithRequestTimeout.
+	ErrRequestTimeout = errors.New("request timed out")
+	// ErrHandlerPanic is passed to the ErrorHandler when a handler panics.
+	ErrHandlerPanic = errors.New("handler panicked")
+)
+
 type Server struct {
-	listener net.Listener
-	handler  Handler
-	isClosed atomic.Bool
+	listener       net.Listener
+	handler        Handler
+	isClosed       atomic.Bool
+	requestTimeout time.Duration
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	limits         request.Limits
+	maxConns       int
+	rejectOverMax  bool
+	retryAfter     time.Duration
+	tlsConfig      *tls.Config
+	errorHandler   ErrorHandler
+	nextConnID     atomic.Uint64
+	accessLog      *accesslog.Logger
+	logger         *slog.Logger
+	metrics        *serverMetrics
+	trustedProxies request.TrustedProxies
+
+	// connSlots holds a token for every connection being served when
+	// WithMaxConnections is set.
+	connSlots chan struct{}
+
+	// closing is closed when the server stops accepting, and acceptDone
+	// once the accept loop has returned. conns tracks the connections being
+	// served.
+	closing    chan struct{}
+	closeOnce  sync.Once
+	closeErr   error
+	acceptDone chan struct{}
+	conns      sync.WaitGroup
+
+	// baseCtx is the parent of every request context and is cancelled by
+	// Close.
+	baseCtx    context.Context
+	cancelBase context.CancelCauseFunc
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	addr := ":" + strconv.Itoa(port)
+// Serve listens on TCP port on all interfaces and serves handler.
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	return ListenAndServe(":"+strconv.Itoa(port), handler, opts...)
+}
 
-	listener, err := net.Listen("tcp", addr)
+// ListenAndServe listens on addr and serves handler. A TCP address picks the
+// interface as well as the port, e.g. ":3000" for all of them,
+// "127.0.0.1:3000", "[::1]:3000" or "[fe80::1%eth0]:3000"; "unix:/path"
+// listens on a Unix domain socket (see Listen).
+func ListenAndServe(addr string, handler Handler, opts ...Option) (*Server, error) {
+	listener, err := Listen(addr)
 	if err != nil {
-		return nil, fmt.Errorf("cannot listen on port %d: %w", port, err)
+		return nil, err
 	}
+	server, err := ServeListener(listener, handler, opts...)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return server, nil
+}
 
+// ServeListener serves handler on connections accepted from listener, for
+// callers that set the listener up themselves. Tests can listen on port 0
+// and read the chosen address back from Addr. The server owns the listener
+// from then on and closes it in Close.
+func ServeListener(listener net.Listener, handler Handler, opts ...Option) (*Server, error) {
 	server := &Server{
-		listener: listener,
-		handler:  handler,
+		handler:      handler,
+		logger:       slog.New(slog.DiscardHandler),
+		errorHandler: defaultErrorHandler,
+		closing:      make(chan struct{}),
+		acceptDone:   make(chan struct{}),
 	}
 	server.isClosed.Store(false)
+	server.baseCtx, server.cancelBase = context.WithCancelCause(context.Background())
+	for _, opt := range opts {
+		opt(server)
+	}
 
+	if server.tlsConfig != nil {
+		if len(server.tlsConfig.Certificates) == 0 && server.tlsConfig.GetCertificate == nil && server.tlsConfig.GetConfigForClient == nil {
+			return nil, errors.New("TLS config has no certificate")
+		}
+		listener = tls.NewListener(listener, server.tlsConfig)
+	}
+	if server.maxConns > 0 {
+		server.connSlots = make(chan struct{}, server.maxConns)
+	}
+	server.listener = listener
+
+	server.logger.Info("server listening", "addr", listener.Addr().String(), "tls", server.tlsConfig != nil)
 	go server.listen()
 
 	return server, nil
 }
 
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the server at once: it stops accepting and cancels the
+// context of every request in progress with ErrServerClosed.
 func (s *Server) Close() error {
-	log.Println("Closing server listener...")
-	s.isClosed.Store(true)
-	return s.listener.Close()
+	s.logger.Info("server closing")
+	err := s.stopAccepting()
+	s.cancelBase(ErrServerClosed)
+	return err
+}
+
+// Shutdown stops the server gracefully: it stops accepting, then waits for
+// the connections being served to finish. If ctx ends first, the remaining
+// requests are cancelled as by Close and ctx's error is returned. Hijacked
+// connections are waited for only as long as their handler runs.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("server shutting down")
+	err := s.stopAccepting()
+	defer s.cancelBase(ErrServerClosed)
+
+	drained := make(chan struct{})
+	go func() {
+		<-s.acceptDone
+		s.conns.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) stopAccepting() error {
+	s.closeOnce.Do(func() {
+		s.isClosed.Store(true)
+		close(s.closing)
+		s.closeErr = s.listener.Close()
+	})
+	return s.closeErr
 }
 
 func (s *Server) listen() {
@@ -54,14 +208,18 @@ func (s *Server) listen() {
 		if !s.isClosed.Load() {
 			s.Close()
 		}
-		log.Println("Listening goroutine stopped.")
+		close(s.acceptDone)
+		s.logger.Debug("accept loop stopped")
 	}()
 
+	var delay time.Duration
 	for {
+		if !s.rejectOverMax && !s.acquireSlot() {
+			return
+		}
 		conn, err := s.listener.Accept()
 
 		if s.isClosed.Load() {
-			log.Println("Listener closed, stopping accept loop.")
 			if conn != nil {
 				conn.Close()
 			}
@@ -69,35 +227,295 @@ func (s *Server) listen() {
 		}
 
 		if err != nil {
-			log.Printf("ERROR: Cannot accept connection: %v", err)
+			if !s.rejectOverMax {
+				s.releaseSlot()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				s.logger.Error("listener closed underneath the server", "category", "accept", "error", err)
+				return
+			}
+			// Errors such as running out of file descriptors usually pass;
+			// back off rather than spin until they do.
+			delay = min(max(2*delay, minAcceptDelay), maxAcceptDelay)
+			s.logger.Error("accept failed", "category", "accept", "error", err, "retry_in", delay)
+			s.metrics.acceptError()
+			if !s.pause(delay) {
+				return
+			}
 			continue
 		}
+		delay = 0
+
+		if s.rejectOverMax && !s.tryAcquireSlot() {
+			s.metrics.connRejected()
+			s.logger.Warn("connection limit reached, rejecting", "category", "overload", "remote_addr", conn.RemoteAddr().String())
+			go s.reject(conn)
+			continue
+		}
+
+		connID := s.nextConnID.Add(1)
+		s.metrics.connAccepted()
+		s.logger.Debug("connection accepted", "conn_id", connID, "remote_addr", conn.RemoteAddr().String())
+		s.conns.Add(1)
+		go s.handle(conn, connID)
+	}
+}
 
-		log.Printf("Accepted connection from %s", conn.RemoteAddr())
-		go s.handle(conn)
+// acquireSlot waits until fewer than the maximum number of connections are
+// being served, so that further clients queue in the listen backlog. It
+// returns false if the server is closed meanwhile.
+func (s *Server) acquireSlot() bool {
+	if s.connSlots == nil {
+		return true
+	}
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	case <-s.closing:
+		return false
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
+// tryAcquireSlot takes a connection slot if one is free.
+func (s *Server) tryAcquireSlot() bool {
+	if s.connSlots == nil {
+		return true
+	}
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseSlot() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+// pause waits for d, returning false if the server is closed meanwhile.
+func (s *Server) pause(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.closing:
+		return false
+	}
+}
+
+// reject answers a connection over the limit with 503 and Retry-After
+// without reading the request. The client's request is drained briefly
+// after the response so that closing does not reset the connection before
+// the client has read it.
+func (s *Server) reject(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	h.Set("Retry-After", strconv.Itoa(int(max(1, math.Ceil(s.retryAfter.Seconds())))))
+	if err := response.NewWriter(conn).WriteError(response.StatusServiceUnavailable, h); err != nil {
+		return
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		io.Copy(io.Discard, io.LimitReader(conn, rejectDrainBytes))
+	}
+}
+
+func (s *Server) handle(conn net.Conn, connID uint64) {
+	logger := s.logger.With("conn_id", connID)
+	start := time.Now()
+	hijacked := false
 	defer func() {
-		conn.Close()
+		if !hijacked {
+			conn.Close()
+		}
+		s.metrics.connClosed()
+		s.releaseSlot()
+		s.conns.Done()
 	}()
 
-	req, err := request.RequestFromReader(conn)
+	if s.readTimeout > 0 {
+		conn.SetReadDeadline(start.Add(s.readTimeout))
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.HandshakeContext(s.baseCtx); err != nil {
+			logger.Debug("TLS handshake failed", "category", "tls", "remote_addr", conn.RemoteAddr().String(), "error", err)
+			return
+		}
+	}
+
+	req, err := request.RequestFromReaderWithLimits(conn, s.limits)
 	if err != nil {
-		log.Printf("ERROR: Cannot read request: %v", err)
+		logger.Warn("malformed request", "category", "parse", "remote_addr", conn.RemoteAddr().String(), "error", err)
 		errWriter := response.NewWriter(conn)
-		errWriter.WriteStatusLine(response.StatusBadRequest)
-		h := headers.NewHeaders()
-		h.Set("Connection", "close")
-		h.Set("Content-Type", "text/plain")
-		errWriter.WriteHeaders(h) // Ghi cả dòng trống
-		errWriter.WriteBody([]byte(fmt.Sprintf("Bad Request: %v\n", err)))
+		s.errorHandler(errWriter, parseErrorStatus(err), err)
+		s.metrics.parseError(err)
+		s.logAccess(start, conn, nil, errWriter)
 		return
 	}
+	conn.SetReadDeadline(time.Time{})
+	if s.writeTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+
+	req.RemoteAddr = conn.RemoteAddr()
+	req.LocalAddr = conn.LocalAddr()
+	req.ConnID = connID
+	// Connections are not reused, so every request is the first on its
+	// connection.
+	req.Sequence = 1
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		req.TLS = &state
+	}
+
+	ctx, cancel := s.requestContext(conn)
+	defer cancel(nil)
+	logger = logger.With("request_id", request.IDFromContext(ctx))
+	req = req.WithContext(request.ContextWithLogger(ctx, logger))
+
+	watcher := watchConn(conn, func() { cancel(ErrClientDisconnected) })
+	defer watcher.stop()
 
 	responseWriter := response.NewWriter(conn)
-	s.handler(responseWriter, req)
+	responseWriter.OnHijack(func() {
+		responseWriter.SetBuffered(append(req.Buffered(), watcher.stop()...))
+		// The new owner manages its own deadlines.
+		conn.SetWriteDeadline(time.Time{})
+	})
+	s.runHandler(responseWriter, req, logger)
+	if err := req.RemoveFormFiles(); err != nil {
+		logger.Warn("cannot remove uploaded files", "category", "fs", "error", err)
+	}
+	if responseWriter.Hijacked() {
+		hijacked = true
+		logger.Debug("connection hijacked")
+		s.metrics.requestDone(req, responseWriter, time.Since(start))
+		s.logAccess(start, conn, req, responseWriter)
+		return
+	}
+	if err := responseWriter.Finish(); err != nil {
+		logger.Warn("cannot finish response", "category", "write", "error", err)
+	}
+	s.metrics.requestDone(req, responseWriter, time.Since(start))
+	s.logAccess(start, conn, req, responseWriter)
+	logger.Debug("connection closed", "status", int(responseWriter.StatusCode()))
+}
+
+// runHandler calls the handler, turning a panic into a 500 response if
+// nothing has been sent yet.
+func (s *Server) runHandler(w *response.Writer, req *request.Request, logger *slog.Logger) {
+	defer func() {
+		if v := recover(); v != nil {
+			err := fmt.Errorf("%w: %v", ErrHandlerPanic, v)
+			logger.Error("handler panicked", "category", "panic", "error", err, "stack", string(debug.Stack()))
+			if w.StatusCode() == 0 && !w.Hijacked() {
+				s.errorHandler(w, response.StatusInternalServerError, err)
+			}
+		}
+	}()
+	s.handler(w, req)
+}
+
+// parseErrorStatus picks the response status for a request that could not
+// be read.
+func parseErrorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusContentTooLarge
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return response.StatusRequestTimeout
+	}
+	return response.StatusBadRequest
+}
+
+// defaultErrorHandler sends a short plain-text response. Parse errors are
+// described to the client; server errors are not.
+func defaultErrorHandler(w *response.Writer, statusCode response.StatusCode, err error) {
+	body := response.StatusText(statusCode)
+	if statusCode < 500 {
+		body += ": " + err.Error()
+	}
+	body += "\n"
+
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/plain")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if w.WriteStatusLine(statusCode) != nil || w.WriteHeaders(h) != nil {
+		return
+	}
+	w.WriteBody([]byte(body))
+}
+
+// logAccess writes the access log entry for a request on conn. req is nil
+// when the request could not be parsed. A hijacked connection is logged when
+// the handler returns, with the status sent before the takeover.
+func (s *Server) logAccess(start time.Time, conn net.Conn, req *request.Request, w *response.Writer) {
+	if s.accessLog == nil {
+		return
+	}
+	e := accesslog.Entry{
+		Time:     start,
+		RemoteIP: hostOf(conn.RemoteAddr()),
+		Status:   int(w.StatusCode()),
+		Bytes:    w.BodyBytesWritten(),
+		Duration: time.Since(start),
+	}
+	if req != nil {
+		if ip := req.ClientIP(s.trustedProxies); ip.IsValid() {
+			e.RemoteIP = ip.String()
+		}
+		e.Method = req.RequestLine.Method
+		e.Target = req.RequestLine.RequestTarget
+		e.Proto = "HTTP/" + req.RequestLine.HttpVersion
+		e.Referer = req.Headers.Get("Referer")
+		e.UserAgent = req.Headers.Get("User-Agent")
+		e.RequestID = request.IDFromContext(req.Context())
+	}
+	s.accessLog.Log(e)
+}
+
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// requestContext derives the context for a request on conn from the
+// server's base context, attaching the request ID and client address and
+// applying the per-request timeout.
+func (s *Server) requestContext(conn net.Conn) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(s.baseCtx)
+	if s.requestTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, s.requestTimeout, ErrRequestTimeout)
+		cancelParent := cancel
+		cancel = func(cause error) {
+			cancelParent(cause)
+			cancelTimeout()
+		}
+	}
+	ctx = request.ContextWithID(ctx, newRequestID())
+	ctx = request.ContextWithRemoteAddr(ctx, conn.RemoteAddr())
+	return ctx, cancel
+}
 
-	log.Printf("Sent response to %s", conn.RemoteAddr())
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
 }
@@ -0,0 +1,678 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"httpfromtcp/internal/accesslog"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/metrics"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves handler on a free loopback port and returns its
+// address.
+func startServer(t *testing.T, handler Handler, opts ...Option) (*Server, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv, err := ServeListener(listener, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv, srv.Addr().String()
+}
+
+// syncBuffer is a bytes.Buffer that the server goroutines and the test can
+// use at the same time.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func sendRequest(t *testing.T, addr, raw string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	return conn
+}
+
+// waitForCancel is a handler that blocks until its context is done and
+// reports the cause.
+func waitForCancel(causes chan<- error) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		ctx := req.Context()
+		select {
+		case <-ctx.Done():
+			causes <- context.Cause(ctx)
+		case <-time.After(5 * time.Second):
+			causes <- nil
+		}
+	}
+}
+
+func TestContextCancelledOnDisconnect(t *testing.T) {
+	causes := make(chan error, 1)
+	_, addr := startServer(t, waitForCancel(causes))
+
+	conn := sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+
+	assert.ErrorIs(t, <-causes, ErrClientDisconnected)
+}
+
+func TestContextCancelledOnTimeout(t *testing.T) {
+	causes := make(chan error, 1)
+	_, addr := startServer(t, waitForCancel(causes), WithRequestTimeout(20*time.Millisecond))
+
+	sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.ErrorIs(t, <-causes, ErrRequestTimeout)
+}
+
+func TestContextCancelledOnClose(t *testing.T) {
+	causes := make(chan error, 1)
+	srv, addr := startServer(t, waitForCancel(causes))
+
+	sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	time.Sleep(20 * time.Millisecond)
+	srv.Close()
+
+	assert.ErrorIs(t, <-causes, ErrServerClosed)
+}
+
+func TestContextValues(t *testing.T) {
+	type values struct {
+		id         string
+		remoteAddr net.Addr
+	}
+	got := make(chan values, 2)
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		ctx := req.Context()
+		got <- values{request.IDFromContext(ctx), request.RemoteAddrFromContext(ctx)}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.NewHeaders())
+	})
+
+	first := sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	v1 := <-got
+	sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	v2 := <-got
+
+	assert.Len(t, v1.id, 16)
+	assert.NotEqual(t, v1.id, v2.id)
+	require.NotNil(t, v1.remoteAddr)
+	assert.Equal(t, first.LocalAddr().String(), v1.remoteAddr.String())
+}
+
+func TestHijackKeepsInputReadWhileHandling(t *testing.T) {
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		// Give the client time to send more bytes, which the server reads
+		// while watching for a disconnect.
+		time.Sleep(50 * time.Millisecond)
+		conn, rw, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("echo: " + line)
+		rw.Flush()
+	})
+
+	conn := sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	_, err := conn.Write([]byte("after request\n"))
+	require.NoError(t, err)
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: after request\n", reply)
+
+	// Nothing else may follow: the server must not finish a response on a
+	// connection the handler has taken over.
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
+
+func TestConnectionMetadata(t *testing.T) {
+	got := make(chan *request.Request, 2)
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		got <- req
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.NewHeaders())
+	})
+
+	conn := sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	r1 := <-got
+	sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	r2 := <-got
+
+	assert.Equal(t, conn.LocalAddr().String(), r1.RemoteAddr.String())
+	assert.Equal(t, addr, r1.LocalAddr.String())
+	assert.Equal(t, 1, r1.Sequence)
+	assert.Nil(t, r1.TLS)
+	assert.NotZero(t, r1.ConnID)
+	assert.NotEqual(t, r1.ConnID, r2.ConnID)
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf syncBuffer
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteError(response.StatusNotFound, headers.NewHeaders())
+	}, WithAccessLog(accesslog.New(&buf, accesslog.Combined)))
+
+	conn := sendRequest(t, addr, "GET /missing HTTP/1.1\r\nHost: x\r\nUser-Agent: test/1.0\r\n\r\n")
+	_, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return buf.String() != "" }, time.Second, 5*time.Millisecond)
+	line := buf.String()
+	clientIP, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	assert.True(t, strings.HasPrefix(line, clientIP+" - - ["), line)
+	assert.Contains(t, line, `"GET /missing HTTP/1.1" 404 14 "-" "test/1.0"`)
+}
+
+func TestAccessLogChunked(t *testing.T) {
+	var buf syncBuffer
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Done")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello"))
+		w.WriteChunkedBody([]byte(", world"))
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Done", "1")
+		w.WriteTrailers(trailers)
+	}, WithAccessLog(accesslog.New(&buf, accesslog.Common)))
+
+	conn := sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	_, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	// The size logged is the body's, without chunk framing or trailers.
+	require.Eventually(t, func() bool { return buf.String() != "" }, time.Second, 5*time.Millisecond)
+	assert.Contains(t, buf.String(), `"GET / HTTP/1.1" 200 12`+"\n")
+}
+
+func TestLogger(t *testing.T) {
+	var buf syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		request.LoggerFromContext(req.Context()).Info("from handler")
+		w.WriteError(response.StatusOK, headers.NewHeaders())
+	}, WithLogger(logger))
+
+	conn := sendRequest(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	io.ReadAll(conn)
+	conn = sendRequest(t, addr, "NOT A REQUEST\r\n\r\n")
+	io.ReadAll(conn)
+
+	records := func() map[string]map[string]any {
+		byMsg := map[string]map[string]any{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record map[string]any
+			if json.Unmarshal([]byte(line), &record) == nil {
+				byMsg[record["msg"].(string)] = record
+			}
+		}
+		return byMsg
+	}
+	require.Eventually(t, func() bool {
+		_, ok := records()["malformed request"]
+		return ok
+	}, time.Second, 5*time.Millisecond)
+
+	got := records()
+	handler := got["from handler"]
+	require.NotNil(t, handler)
+	assert.EqualValues(t, 1, handler["conn_id"])
+	assert.Len(t, handler["request_id"], 16)
+
+	malformed := got["malformed request"]
+	assert.Equal(t, "WARN", malformed["level"])
+	assert.Equal(t, "parse", malformed["category"])
+	assert.EqualValues(t, 2, malformed["conn_id"])
+}
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteError(response.StatusNotFound, headers.NewHeaders())
+	}, WithMetrics(reg))
+
+	io.ReadAll(sendRequest(t, addr, "POST /x HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc"))
+	io.ReadAll(sendRequest(t, addr, "GET /x HTTP/1.0\r\n\r\n"))
+	io.ReadAll(sendRequest(t, addr, "GET /x HTTP/1.1\r\nbad header\r\n\r\n"))
+
+	scrape := func() string {
+		var buf bytes.Buffer
+		reg.WriteTo(&buf)
+		return buf.String()
+	}
+	require.Eventually(t, func() bool {
+		return strings.Contains(scrape(), "http_server_connections_closed_total 3")
+	}, time.Second, 5*time.Millisecond)
+
+	out := scrape()
+	assert.Contains(t, out, "http_server_connections_accepted_total 3\n")
+	assert.Contains(t, out, "http_server_active_connections 0\n")
+	assert.Contains(t, out, `http_server_requests_total{method="POST",code="4xx"} 1`)
+	assert.Contains(t, out, `http_server_parse_errors_total{type="header"} 1`)
+	assert.Contains(t, out, `http_server_parse_errors_total{type="version"} 1`)
+	assert.Contains(t, out, "http_server_request_body_bytes_total 3\n")
+	assert.Contains(t, out, "http_server_response_body_bytes_total 14\n")
+	assert.Contains(t, out, `http_server_request_duration_seconds_count{method="POST"} 1`)
+}
+
+func okHandler(w *response.Writer, req *request.Request) {
+	w.WriteError(response.StatusOK, headers.NewHeaders())
+}
+
+// statusLine sends raw and returns the first line of the reply.
+func statusLine(t *testing.T, addr, raw string) string {
+	t.Helper()
+	conn := sendRequest(t, addr, raw)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	return strings.TrimSpace(line)
+}
+
+func TestSetCookie(t *testing.T) {
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Path: "/", HttpOnly: true}))
+		require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}))
+		assert.ErrorIs(t, w.SetCookie(&cookie.Cookie{Name: "bad name"}), cookie.ErrInvalidCookie)
+		okHandler(w, req)
+		assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "late"}))
+	})
+
+	out, err := io.ReadAll(sendRequest(t, addr, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "\r\nset-cookie: a=1; Path=/; HttpOnly\r\n")
+	assert.Contains(t, string(out), "\r\nset-cookie: b=2; Expires=Tue, 01 Jan 2030 00:00:00 GMT\r\n")
+	assert.Equal(t, 2, strings.Count(string(out), "set-cookie:"))
+}
+
+func TestListenAndServe(t *testing.T) {
+	srv, err := ListenAndServe("127.0.0.1:0", okHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	addr := srv.Addr().(*net.TCPAddr)
+	assert.True(t, addr.IP.IsLoopback())
+	assert.NotZero(t, addr.Port)
+	assert.Equal(t, "HTTP/1.1 200 OK", statusLine(t, addr.String(), "GET / HTTP/1.1\r\n\r\n"))
+}
+
+func TestLimits(t *testing.T) {
+	_, addr := startServer(t, okHandler, WithLimits(request.Limits{MaxHeaderBytes: 64, MaxBodyBytes: 4}))
+
+	assert.Equal(t, "HTTP/1.1 200 OK", statusLine(t, addr, "POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\nabcd"))
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", statusLine(t, addr, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nabcde"))
+	assert.Equal(t, "HTTP/1.1 431 Request Header Fields Too Large",
+		statusLine(t, addr, "GET / HTTP/1.1\r\nX-Long: "+strings.Repeat("a", 64)+"\r\n\r\n"))
+}
+
+func TestReadTimeout(t *testing.T) {
+	_, addr := startServer(t, okHandler, WithReadTimeout(20*time.Millisecond))
+	assert.Equal(t, "HTTP/1.1 408 Request Timeout", statusLine(t, addr, "GET / HTTP/1.1\r\n"))
+}
+
+func TestMaxConnections(t *testing.T) {
+	release := make(chan struct{})
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		<-release
+		okHandler(w, req)
+	}, WithMaxConnections(1))
+
+	first := sendRequest(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	second := sendRequest(t, addr, "GET / HTTP/1.1\r\n\r\n")
+
+	// The second client is not served while the first holds the only slot.
+	second.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := second.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	second.SetReadDeadline(time.Time{})
+
+	close(release)
+	for _, conn := range []net.Conn{first, second} {
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+	}
+}
+
+func TestRejectOverLimit(t *testing.T) {
+	reg := metrics.NewRegistry()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-release
+		okHandler(w, req)
+	}, WithMaxConnections(1), WithRejectOverLimit(1500*time.Millisecond), WithMetrics(reg))
+
+	first := sendRequest(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	<-started
+
+	rejected, err := io.ReadAll(sendRequest(t, addr, "GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(rejected), "HTTP/1.1 503 Service Unavailable\r\n"), string(rejected))
+	assert.Contains(t, string(rejected), "retry-after: 2\r\n")
+
+	close(release)
+	line, err := bufio.NewReader(first).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+	assert.Contains(t, buf.String(), "http_server_connections_rejected_total 1\n")
+	assert.Contains(t, buf.String(), "http_server_connections_accepted_total 1\n")
+}
+
+// failingListener fails every Accept until it is closed.
+type failingListener struct {
+	accepts atomic.Int32
+	closed  chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+		return nil, syscall.EMFILE
+	}
+}
+
+func (l *failingListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *failingListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	reg := metrics.NewRegistry()
+	listener := &failingListener{closed: make(chan struct{})}
+	srv, err := ServeListener(listener, okHandler, WithMetrics(reg))
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	// 5ms, 10ms, 20ms, 40ms... fit about five attempts in 100ms; a loop
+	// without backoff would make thousands.
+	accepts := listener.accepts.Load()
+	assert.GreaterOrEqual(t, accepts, int32(3))
+	assert.LessOrEqual(t, accepts, int32(7))
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+	assert.Contains(t, buf.String(), "http_server_accept_errors_total ")
+
+	closed := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not interrupt the backoff")
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	})
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", statusLine(t, addr, "GET / HTTP/1.1\r\n\r\n"))
+}
+
+func TestErrorHandler(t *testing.T) {
+	errs := make(chan error, 2)
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}, WithErrorHandler(func(w *response.Writer, statusCode response.StatusCode, err error) {
+		errs <- err
+		w.WriteError(response.StatusServiceUnavailable, headers.NewHeaders())
+	}))
+
+	assert.Equal(t, "HTTP/1.1 503 Service Unavailable", statusLine(t, addr, "BAD\r\n\r\n"))
+	assert.ErrorIs(t, <-errs, request.ErrMalformedRequestLine)
+	assert.Equal(t, "HTTP/1.1 503 Service Unavailable", statusLine(t, addr, "GET / HTTP/1.1\r\n\r\n"))
+	assert.ErrorIs(t, <-errs, ErrHandlerPanic)
+}
+
+func TestTLS(t *testing.T) {
+	cert := selfSignedCert(t)
+	gotTLS := make(chan *tls.ConnectionState, 1)
+	_, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		gotTLS <- req.TLS
+		okHandler(w, req)
+	}, WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+	state := <-gotTLS
+	require.NotNil(t, state)
+	assert.True(t, state.HandshakeComplete)
+}
+
+func TestTLSRequiresCertificate(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, err = ServeListener(listener, okHandler, WithTLS(&tls.Config{}))
+	assert.Error(t, err)
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// socketPath returns a path for a Unix socket in a fresh directory, short
+// enough for the sun_path limit.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "srv")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "s.sock")
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := socketPath(t)
+	var logBuf syncBuffer
+	srv, err := ListenAndServe(UnixPrefix+path, okHandler, WithAccessLog(accesslog.New(&logBuf, accesslog.Common)))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+	require.Eventually(t, func() bool { return logBuf.String() != "" }, time.Second, 5*time.Millisecond)
+	assert.Contains(t, logBuf.String(), `"GET / HTTP/1.1" 200`)
+
+	srv.Close()
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, fs.ErrNotExist, "socket file is removed on close")
+}
+
+func TestListenUnix(t *testing.T) {
+	t.Run("Stale socket is replaced", func(t *testing.T) {
+		path := socketPath(t)
+		stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		require.NoError(t, err)
+		stale.SetUnlinkOnClose(false)
+		stale.Close()
+
+		listener, err := ListenUnix(path, 0o600)
+		require.NoError(t, err)
+		defer listener.Close()
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, fs.FileMode(0o600), info.Mode().Perm())
+	})
+
+	t.Run("Live socket is kept", func(t *testing.T) {
+		path := socketPath(t)
+		live, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer live.Close()
+
+		_, err = ListenUnix(path, 0)
+		assert.ErrorIs(t, err, syscall.EADDRINUSE)
+	})
+
+	t.Run("Other files are kept", func(t *testing.T) {
+		path := socketPath(t)
+		require.NoError(t, os.WriteFile(path, []byte("data"), 0o644))
+
+		_, err := ListenUnix(path, 0)
+		assert.Error(t, err)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, "data", string(data))
+	})
+}
+
+func TestSystemdListeners(t *testing.T) {
+	inherited, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer inherited.Close()
+	f, err := inherited.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	env := map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "1", "LISTEN_FDNAMES": "http"}
+	getenv := func(key string) string { return env[key] }
+
+	listeners, err := systemdListeners(getenv, 42, int(f.Fd()))
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	defer listeners[0].Close()
+	assert.Equal(t, inherited.Addr().String(), listeners[0].Addr().String())
+
+	// Sockets passed to another process are not ours.
+	listeners, err = systemdListeners(getenv, 43, int(f.Fd()))
+	require.NoError(t, err)
+	assert.Nil(t, listeners)
+
+	// Nor is anything when not socket-activated.
+	listeners, err = systemdListeners(func(string) string { return "" }, 42, int(f.Fd()))
+	require.NoError(t, err)
+	assert.Nil(t, listeners)
+}
+
+func TestShutdownDrains(t *testing.T) {
+	release := make(chan struct{})
+	srv, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		<-release
+		okHandler(w, req)
+	})
+	conn := sendRequest(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+
+	// New connections are refused while the old one finishes.
+	require.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			c.Close()
+		}
+		return err != nil
+	}, time.Second, 5*time.Millisecond)
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned before the request finished")
+	default:
+	}
+
+	close(release)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", line)
+	assert.NoError(t, <-shutdown)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	causes := make(chan error, 1)
+	srv, addr := startServer(t, waitForCancel(causes))
+	sendRequest(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+	assert.ErrorIs(t, <-causes, ErrServerClosed)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connWatcher keeps a read pending on the connection while the handler runs,
+// so that a client hanging up is noticed and the request's context can be
+// cancelled. Anything the client sends in the meantime is kept, not lost.
+type connWatcher struct {
+	conn     net.Conn
+	done     chan struct{}
+	aborted  atomic.Bool
+	stopOnce sync.Once
+	buf      []byte
+}
+
+func watchConn(conn net.Conn, onDisconnect func()) *connWatcher {
+	cw := &connWatcher{conn: conn, done: make(chan struct{})}
+	go func() {
+		defer close(cw.done)
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		cw.buf = buf[:n]
+		if err != nil && !cw.aborted.Load() {
+			onDisconnect()
+		}
+	}()
+	return cw
+}
+
+// stop interrupts the pending read and returns whatever it picked up. It is
+// safe to call more than once.
+func (cw *connWatcher) stop() []byte {
+	cw.stopOnce.Do(func() {
+		cw.aborted.Store(true)
+		cw.conn.SetReadDeadline(time.Unix(1, 0))
+		<-cw.done
+		cw.conn.SetReadDeadline(time.Time{})
+	})
+	return cw.buf
+}
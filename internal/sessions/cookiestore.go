@@ -0,0 +1,173 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxCookieSize is the size browsers are guaranteed to store for a cookie's
+// name and value together (RFC 6265bis 5.6).
+const maxCookieSize = 4096
+
+// ErrTooLarge is returned by CookieStore.Save when the session does not fit
+// in a cookie.
+var ErrTooLarge = errors.New("session too large for a cookie")
+
+// Keys are the secrets a CookieStore protects sessions with.
+type Keys struct {
+	// Signing authenticates the cookie with HMAC-SHA256. It must be at
+	// least 32 bytes of random data.
+	Signing []byte
+	// Encryption, if set, also encrypts the cookie with AES-GCM so the
+	// client cannot read it. It must be 16, 24 or 32 bytes.
+	Encryption []byte
+}
+
+// CookieStore keeps sessions in the cookie itself, so they need no
+// server-side state and survive restarts. Anything stored is visible to
+// the client unless the keys include an encryption key.
+type CookieStore struct {
+	maxAge time.Duration
+	keys   []storeKeys
+	now    func() time.Time
+}
+
+type storeKeys struct {
+	signing []byte
+	aead    cipher.AEAD
+}
+
+// NewCookieStore returns a store for sessions lasting maxAge (zero for the
+// browser session). Cookies are written with the first keys and accepted
+// with any of them, so keys can be rotated by putting the new ones first
+// and dropping old ones once their cookies have expired.
+func NewCookieStore(maxAge time.Duration, keys ...Keys) (*CookieStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("sessions: no keys")
+	}
+	cs := &CookieStore{maxAge: maxAge, now: time.Now}
+	for i, k := range keys {
+		if len(k.Signing) < 32 {
+			return nil, fmt.Errorf("sessions: signing key %d is shorter than 32 bytes", i)
+		}
+		sk := storeKeys{signing: k.Signing}
+		if k.Encryption != nil {
+			block, err := aes.NewCipher(k.Encryption)
+			if err != nil {
+				return nil, fmt.Errorf("sessions: encryption key %d: %w", i, err)
+			}
+			sk.aead, _ = cipher.NewGCM(block)
+		}
+		cs.keys = append(cs.keys, sk)
+	}
+	return cs, nil
+}
+
+func (cs *CookieStore) MaxAge() time.Duration {
+	return cs.maxAge
+}
+
+// Load verifies and decodes a cookie value. Values that were tampered with,
+// are older than the store's max age or were written with a key no longer
+// configured give ErrInvalidSession.
+func (cs *CookieStore) Load(name, value string) (*Session, error) {
+	if value == "" {
+		return newSession(), nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) < sha256.Size {
+		return nil, fmt.Errorf("%w: malformed cookie", ErrInvalidSession)
+	}
+	data, mac := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+
+	for _, k := range cs.keys {
+		if !hmac.Equal(mac, sign(k.signing, name, data)) {
+			continue
+		}
+		payload, err := k.open(data)
+		if err != nil {
+			return nil, err
+		}
+		return cs.decode(payload)
+	}
+	return nil, fmt.Errorf("%w: bad signature", ErrInvalidSession)
+}
+
+// Save encodes the session, encrypts it if configured and signs it. The
+// cookie name is covered by the signature, so a value cannot be replayed
+// under another cookie.
+func (cs *CookieStore) Save(name string, s *Session) (string, error) {
+	if s.destroy {
+		return "", nil
+	}
+	encoded, err := json.Marshal(s.values)
+	if err != nil {
+		return "", err
+	}
+	payload := binary.BigEndian.AppendUint64(nil, uint64(cs.now().Unix()))
+	payload = append(payload, encoded...)
+
+	k := cs.keys[0]
+	data := k.seal(payload)
+	value := base64.RawURLEncoding.EncodeToString(append(data, sign(k.signing, name, data)...))
+	if len(name)+len(value) > maxCookieSize {
+		return "", ErrTooLarge
+	}
+	return value, nil
+}
+
+func (cs *CookieStore) decode(payload []byte) (*Session, error) {
+	if len(payload) < 8 {
+		return nil, fmt.Errorf("%w: malformed cookie", ErrInvalidSession)
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if cs.maxAge > 0 && cs.now().Sub(issued) > cs.maxAge {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidSession)
+	}
+	values := make(map[string]string)
+	if err := json.Unmarshal(payload[8:], &values); err != nil {
+		return nil, fmt.Errorf("%w: malformed cookie", ErrInvalidSession)
+	}
+	return &Session{values: values}, nil
+}
+
+func sign(key []byte, name string, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (k storeKeys) seal(payload []byte) []byte {
+	if k.aead == nil {
+		return payload
+	}
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(payload)+k.aead.Overhead())
+	rand.Read(nonce)
+	return k.aead.Seal(nonce, nonce, payload, nil)
+}
+
+func (k storeKeys) open(data []byte) ([]byte, error) {
+	if k.aead == nil {
+		return data, nil
+	}
+	if len(data) < k.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed cookie", ErrInvalidSession)
+	}
+	nonce, ciphertext := data[:k.aead.NonceSize()], data[k.aead.NonceSize():]
+	payload, err := k.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot decrypt", ErrInvalidSession)
+	}
+	return payload, nil
+}
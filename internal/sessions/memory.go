@@ -0,0 +1,104 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"maps"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long a MemoryStore keeps a session that is not saved
+// again, when no TTL is given.
+const DefaultTTL = 24 * time.Hour
+
+// MemoryStore keeps sessions in memory, so they are lost when the process
+// exits. The cookie only carries a random session ID.
+type MemoryStore struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	sessions  map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	values  map[string]string
+	expires time.Time
+}
+
+// NewMemoryStore returns a store whose sessions expire ttl after they were
+// last saved (DefaultTTL if zero).
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &MemoryStore{ttl: ttl, now: time.Now, sessions: make(map[string]memoryEntry)}
+}
+
+func (m *MemoryStore) MaxAge() time.Duration {
+	return m.ttl
+}
+
+// Load returns a new session for an unknown or expired ID; the ID the
+// client sent is never adopted.
+func (m *MemoryStore) Load(name, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+	entry, ok := m.sessions[id]
+	if !ok || !now.Before(entry.expires) {
+		return newSession(), nil
+	}
+	return &Session{id: id, values: maps.Clone(entry.values)}, nil
+}
+
+func (m *MemoryStore) Save(name string, s *Session) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s.id != "" && (s.destroy || s.renew) {
+		delete(m.sessions, s.id)
+		s.id = ""
+	}
+	if s.destroy {
+		return "", nil
+	}
+	if s.id == "" {
+		s.id = newID()
+	}
+	s.renew = false
+	m.sessions[s.id] = memoryEntry{values: maps.Clone(s.values), expires: m.now().Add(m.ttl)}
+	return s.id, nil
+}
+
+// Len returns the number of sessions held, including expired ones not
+// evicted yet.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// sweep evicts expired sessions, at most once a minute.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for id, entry := range m.sessions {
+		if !now.Before(entry.expires) {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+// newID returns 256 random bits, which cannot be guessed.
+func newID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,182 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"maps"
+	"time"
+)
+
+// DefaultCookieName names the session cookie when Config.CookieName is
+// empty.
+const DefaultCookieName = "session"
+
+// ErrInvalidSession is returned by a Store for a cookie value it cannot
+// accept, such as one that was tampered with or has expired.
+var ErrInvalidSession = errors.New("invalid session")
+
+// Session holds the values kept for one client between requests. It
+// belongs to the request it was loaded for and is not safe for concurrent
+// use.
+type Session struct {
+	id       string
+	values   map[string]string
+	isNew    bool
+	modified bool
+	renew    bool
+	destroy  bool
+}
+
+func newSession() *Session {
+	return &Session{values: make(map[string]string), isNew: true}
+}
+
+// ID returns the identifier a server-side store keeps the session under,
+// or "" if it has none (yet).
+func (s *Session) ID() string {
+	return s.id
+}
+
+// IsNew reports whether the client had no valid session.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+func (s *Session) Get(key string) string {
+	return s.values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	s.values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.values, key)
+	s.modified = true
+}
+
+// Values returns a copy of the session's values.
+func (s *Session) Values() map[string]string {
+	return maps.Clone(s.values)
+}
+
+// Renew gives the session a new ID when it is saved, keeping its values.
+// Call it when the client's privileges change, e.g. on login, so that an
+// ID planted beforehand by an attacker becomes useless.
+func (s *Session) Renew() {
+	s.renew = true
+	s.modified = true
+}
+
+// Destroy deletes the session from the store and the client when the
+// response is sent.
+func (s *Session) Destroy() {
+	s.destroy = true
+	s.modified = true
+}
+
+// Store loads and saves sessions. A Store must be safe for concurrent use.
+type Store interface {
+	// Load returns the session for the value of the cookie called name, or
+	// a new session if value is empty or names a session that no longer
+	// exists. Values that cannot be trusted give ErrInvalidSession.
+	Load(name, value string) (*Session, error)
+	// Save stores s and returns the cookie value that refers to it. For a
+	// destroyed session it removes any stored state and returns "".
+	Save(name string, s *Session) (string, error)
+	// MaxAge is how long a session lasts after it was last saved; zero
+	// means until the browser is closed.
+	MaxAge() time.Duration
+}
+
+type Config struct {
+	Store      Store
+	CookieName string
+	// Path and Domain scope the cookie; Path defaults to "/".
+	Path   string
+	Domain string
+	// Secure restricts the cookie to HTTPS.
+	Secure bool
+	// SameSite defaults to Lax.
+	SameSite cookie.SameSite
+}
+
+type contextKey struct{}
+
+// FromContext returns the session Middleware loaded for the request, or
+// nil.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+// Middleware returns middleware that loads the client's session before
+// calling next and, if next changed it, saves it and sets the session
+// cookie when the response headers are written. The cookie is always
+// HttpOnly. A session cookie the store rejects is replaced by a new
+// session.
+func Middleware(cfg Config) func(next server.Handler) server.Handler {
+	if cfg.CookieName == "" {
+		cfg.CookieName = DefaultCookieName
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.SameSite == cookie.SameSiteDefault {
+		cfg.SameSite = cookie.SameSiteLax
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			logger := request.LoggerFromContext(req.Context())
+
+			var value string
+			if c, err := req.Cookie(cfg.CookieName); err == nil {
+				value = c.Value
+			}
+			s, err := cfg.Store.Load(cfg.CookieName, value)
+			if err != nil {
+				logger.Info("discarding session", "category", "session", "error", err)
+				s = newSession()
+			}
+
+			w.OnWriteHeaders(func(statusCode response.StatusCode, h headers.Headers) {
+				if !s.modified {
+					return
+				}
+				if err := cfg.save(w, s); err != nil {
+					logger.Error("cannot save session", "category", "session", "error", err)
+				}
+			})
+			next(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, s)))
+		}
+	}
+}
+
+func (cfg Config) save(w *response.Writer, s *Session) error {
+	value, err := cfg.Store.Save(cfg.CookieName, s)
+	if err != nil {
+		return err
+	}
+	c := &cookie.Cookie{
+		Name:     cfg.CookieName,
+		Value:    value,
+		Path:     cfg.Path,
+		Domain:   cfg.Domain,
+		Secure:   cfg.Secure,
+		HttpOnly: true,
+		SameSite: cfg.SameSite,
+	}
+	if s.destroy {
+		c.MaxAge = -1
+	} else if maxAge := cfg.Store.MaxAge(); maxAge > 0 {
+		c.MaxAge = int(maxAge.Seconds())
+	}
+	return w.SetCookie(c)
+}
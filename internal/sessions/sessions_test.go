@@ -0,0 +1,215 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/base64"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var setCookie = regexp.MustCompile(`(?m)^set-cookie: (.*)\r$`)
+
+// run sends a request carrying cookieHeader through the middleware, lets
+// handle work on the session and returns the Set-Cookie line, if any.
+func run(t *testing.T, cfg Config, cookieHeader string, handle func(s *Session)) string {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\n"
+	if cookieHeader != "" {
+		raw += "Cookie: " + cookieHeader + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	Middleware(cfg)(func(w *response.Writer, req *request.Request) {
+		s := FromContext(req.Context())
+		require.NotNil(t, s)
+		handle(s)
+		w.WriteError(response.StatusOK, nil)
+	})(w, req)
+	require.NoError(t, w.Finish())
+
+	m := setCookie.FindStringSubmatch(buf.String())
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// cookiePair extracts "name=value" from a Set-Cookie line.
+func cookiePair(line string) string {
+	pair, _, _ := strings.Cut(line, ";")
+	return pair
+}
+
+func testStore(t *testing.T, store Store) {
+	cfg := Config{Store: store, CookieName: "sid", Secure: true}
+
+	line := run(t, cfg, "", func(s *Session) {
+		assert.True(t, s.IsNew())
+		s.Set("user", "alice")
+	})
+	require.NotEmpty(t, line)
+	assert.Contains(t, line, "; Path=/")
+	assert.Contains(t, line, "; Max-Age=3600")
+	assert.Contains(t, line, "; Secure; HttpOnly; SameSite=Lax")
+
+	// Reading a session does not rewrite the cookie.
+	assert.Empty(t, run(t, cfg, cookiePair(line), func(s *Session) {
+		assert.False(t, s.IsNew())
+		assert.Equal(t, "alice", s.Get("user"))
+	}))
+
+	line = run(t, cfg, cookiePair(line), func(s *Session) {
+		s.Delete("user")
+		s.Set("cart", "3")
+	})
+	run(t, cfg, cookiePair(line), func(s *Session) {
+		assert.Equal(t, map[string]string{"cart": "3"}, s.Values())
+	})
+
+	line = run(t, cfg, cookiePair(line), func(s *Session) { s.Destroy() })
+	assert.Equal(t, "sid=; Path=/; Max-Age=0; Secure; HttpOnly; SameSite=Lax", line)
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	testStore(t, store)
+
+	cfg := Config{Store: store}
+	line := run(t, cfg, "", func(s *Session) { s.Set("a", "1") })
+	oldID := strings.TrimPrefix(cookiePair(line), "session=")
+
+	// An ID the store did not issue is not adopted.
+	run(t, cfg, "session=planted", func(s *Session) {
+		assert.True(t, s.IsNew())
+		assert.Empty(t, s.ID())
+	})
+
+	line = run(t, cfg, "session="+oldID, func(s *Session) {
+		assert.Equal(t, oldID, s.ID())
+		s.Renew()
+	})
+	newID := strings.TrimPrefix(cookiePair(line), "session=")
+	assert.NotEqual(t, oldID, newID)
+	run(t, cfg, "session="+oldID, func(s *Session) { assert.True(t, s.IsNew()) })
+	run(t, cfg, "session="+newID, func(s *Session) { assert.Equal(t, "1", s.Get("a")) })
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	now := time.Unix(1_700_000_000, 0)
+	store.now = func() time.Time { return now }
+
+	s, err := store.Load("session", "")
+	require.NoError(t, err)
+	s.Set("a", "1")
+	id, err := store.Save("session", s)
+	require.NoError(t, err)
+	for range 10 {
+		store.Save("session", newSession())
+	}
+	assert.Equal(t, 11, store.Len())
+
+	now = now.Add(time.Hour)
+	s, err = store.Load("session", id)
+	require.NoError(t, err)
+	assert.True(t, s.IsNew())
+	assert.Equal(t, 0, store.Len())
+}
+
+func newKey(b byte, n int) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+func TestCookieStore(t *testing.T) {
+	signed, err := NewCookieStore(time.Hour, Keys{Signing: newKey(1, 32)})
+	require.NoError(t, err)
+	testStore(t, signed)
+
+	encrypted, err := NewCookieStore(time.Hour, Keys{Signing: newKey(1, 32), Encryption: newKey(2, 32)})
+	require.NoError(t, err)
+	testStore(t, encrypted)
+
+	s := newSession()
+	s.Set("secret", "plaintext-marker")
+	value, err := signed.Save("session", s)
+	require.NoError(t, err)
+	raw, _ := base64.RawURLEncoding.DecodeString(value)
+	assert.Contains(t, string(raw), "plaintext-marker")
+
+	value, err = encrypted.Save("session", s)
+	require.NoError(t, err)
+	raw, _ = base64.RawURLEncoding.DecodeString(value)
+	assert.NotContains(t, string(raw), "plaintext-marker")
+	loaded, err := encrypted.Load("session", value)
+	require.NoError(t, err)
+	assert.Equal(t, "plaintext-marker", loaded.Get("secret"))
+
+	// The signature covers the cookie name and every byte.
+	_, err = encrypted.Load("other", value)
+	assert.ErrorIs(t, err, ErrInvalidSession)
+	raw[3] ^= 1
+	_, err = encrypted.Load("session", base64.RawURLEncoding.EncodeToString(raw))
+	assert.ErrorIs(t, err, ErrInvalidSession)
+	_, err = encrypted.Load("session", "!!")
+	assert.ErrorIs(t, err, ErrInvalidSession)
+
+	big := newSession()
+	big.Set("blob", strings.Repeat("x", maxCookieSize))
+	_, err = signed.Save("session", big)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestCookieStoreRotationAndExpiry(t *testing.T) {
+	oldKeys := Keys{Signing: newKey(1, 32), Encryption: newKey(2, 16)}
+	newKeys := Keys{Signing: newKey(3, 32), Encryption: newKey(4, 16)}
+	old, err := NewCookieStore(time.Hour, oldKeys)
+	require.NoError(t, err)
+	rotated, err := NewCookieStore(time.Hour, newKeys, oldKeys)
+	require.NoError(t, err)
+	retired, err := NewCookieStore(time.Hour, newKeys)
+	require.NoError(t, err)
+
+	s := newSession()
+	s.Set("a", "1")
+	value, err := old.Save("session", s)
+	require.NoError(t, err)
+
+	loaded, err := rotated.Load("session", value)
+	require.NoError(t, err)
+	assert.Equal(t, "1", loaded.Get("a"))
+	_, err = retired.Load("session", value)
+	assert.ErrorIs(t, err, ErrInvalidSession)
+
+	now := time.Now()
+	rotated.now = func() time.Time { return now.Add(time.Hour + time.Second) }
+	_, err = rotated.Load("session", value)
+	assert.ErrorIs(t, err, ErrInvalidSession)
+
+	_, err = NewCookieStore(0, Keys{Signing: newKey(1, 16)})
+	assert.Error(t, err)
+	_, err = NewCookieStore(0, Keys{Signing: newKey(1, 32), Encryption: newKey(2, 20)})
+	assert.Error(t, err)
+}
+
+func TestMiddlewareDiscardsInvalidCookie(t *testing.T) {
+	store, err := NewCookieStore(0, Keys{Signing: newKey(1, 32)})
+	require.NoError(t, err)
+	cfg := Config{Store: store}
+
+	line := run(t, cfg, "session=forged", func(s *Session) {
+		assert.True(t, s.IsNew())
+		s.Set("a", "1")
+	})
+	assert.NotContains(t, line, "Max-Age", "a zero max age makes a browser-session cookie")
+	run(t, cfg, cookiePair(line), func(s *Session) { assert.Equal(t, "1", s.Get("a")) })
+}
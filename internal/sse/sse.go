@@ -0,0 +1,206 @@
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeartbeat is how often a comment line is sent on an otherwise idle
+// stream when Options.Heartbeat is zero. It keeps intermediaries from timing
+// the connection out and surfaces disconnected clients as write errors.
+const DefaultHeartbeat = 15 * time.Second
+
+type Options struct {
+	// Heartbeat is the interval between keep-alive comments. A negative
+	// value disables heartbeats.
+	Heartbeat time.Duration
+}
+
+// Event is one message on an event stream (WHATWG HTML 9.2.6). Empty fields
+// are omitted; an event without Data only updates the client's state, such
+// as its reconnection time, and dispatches nothing.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+var ErrClosed = errors.New("sse: stream closed")
+
+// Stream writes Server-Sent Events to a response. Its methods are safe for
+// concurrent use.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+	stop   chan struct{}
+}
+
+// LastEventID returns the Last-Event-ID a reconnecting client sent, or "".
+func LastEventID(req *request.Request) string {
+	return req.Headers.Get("Last-Event-ID")
+}
+
+// NewStream starts an event stream: it writes a 200 response with
+// "Content-Type: text/event-stream" and a chunked body, then begins sending
+// heartbeats. The handler should Send events and Close the stream when done;
+// a stream still open when the response is finished is closed then, so the
+// heartbeat never writes past the end of the body.
+func NewStream(w *response.Writer, req *request.Request, opts Options) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream; charset=utf-8")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("X-Accel-Buffering", "no")
+
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		w:           w,
+		lastEventID: LastEventID(req),
+		stop:        make(chan struct{}),
+	}
+	w.OnFinish(func() { s.Close() })
+
+	interval := opts.Heartbeat
+	if interval == 0 {
+		interval = DefaultHeartbeat
+	}
+	if interval > 0 {
+		go s.heartbeat(req.Context().Done(), interval)
+	}
+	return s, nil
+}
+
+// LastEventID returns the Last-Event-ID header of the request that opened
+// the stream, so a handler can resume after the last event the client saw.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send writes ev and flushes it to the client.
+func (s *Stream) Send(ev Event) error {
+	for _, field := range []string{ev.ID, ev.Event} {
+		if strings.ContainsAny(field, "\r\n\x00") {
+			return fmt.Errorf("sse: id and event must be a single line, got %q", field)
+		}
+	}
+
+	var b strings.Builder
+	if ev.ID != "" {
+		b.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Event != "" {
+		b.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	if ev.Data != "" {
+		for _, line := range splitLines(ev.Data) {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Close stops the heartbeat and ends the response body. It returns the first
+// write error the stream ran into, if any.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return s.err
+	}
+	s.closed = true
+	close(s.stop)
+
+	if s.err == nil {
+		s.err = s.w.WriteChunkedBodyDone()
+	}
+	return s.err
+}
+
+// Err returns the error that broke the stream, typically because the client
+// went away, or nil while it is healthy.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Stream) write(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed {
+		return ErrClosed
+	}
+
+	if _, err := s.w.WriteChunkedBody([]byte(data)); err != nil {
+		s.err = err
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+// heartbeat sends keep-alive comments until the stream is closed or the
+// request's context is done.
+func (s *Stream) heartbeat(ctxDone <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ctxDone:
+			return
+		case <-ticker.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// splitLines breaks text on any of the line endings the event stream format
+// recognises, so each line can get its own field.
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,114 @@
+package sse
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer lets the test read output while the heartbeat goroutine writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestStream(t *testing.T, rawRequest string, opts Options) (*Stream, *syncBuffer) {
+	t.Helper()
+	s, _, out := newTestStreamWriter(t, rawRequest, opts)
+	return s, out
+}
+
+func newTestStreamWriter(t *testing.T, rawRequest string, opts Options) (*Stream, *response.Writer, *syncBuffer) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+	require.NoError(t, err)
+	out := &syncBuffer{}
+	w := response.NewWriter(out)
+	s, err := NewStream(w, req, opts)
+	require.NoError(t, err)
+	return s, w, out
+}
+
+func TestStream(t *testing.T) {
+	s, out := newTestStream(t, "GET /events HTTP/1.1\r\nLast-Event-ID: 41\r\n\r\n", Options{Heartbeat: -1})
+	assert.Equal(t, "41", s.LastEventID())
+
+	require.NoError(t, s.Send(Event{ID: "42", Event: "update", Data: "line one\nline two", Retry: 3 * time.Second}))
+	require.NoError(t, s.Send(Event{Data: "plain"}))
+	require.NoError(t, s.Close())
+	assert.ErrorIs(t, s.Send(Event{Data: "late"}), ErrClosed)
+
+	head, body, found := strings.Cut(out.String(), "\r\n\r\n")
+	require.True(t, found)
+	assert.Contains(t, head, "HTTP/1.1 200 OK")
+	assert.Contains(t, head, "content-type: text/event-stream; charset=utf-8")
+	assert.Contains(t, head, "cache-control: no-cache")
+	assert.Contains(t, head, "transfer-encoding: chunked")
+
+	first := "id: 42\nevent: update\nretry: 3000\ndata: line one\ndata: line two\n\n"
+	second := "data: plain\n\n"
+	chunk := func(s string) string { return fmt.Sprintf("%x\r\n%s\r\n", len(s), s) }
+	assert.Equal(t, chunk(first)+chunk(second)+"0\r\n\r\n", body)
+}
+
+func TestSendRejectsMultilineID(t *testing.T) {
+	s, _ := newTestStream(t, "GET /events HTTP/1.1\r\n\r\n", Options{Heartbeat: -1})
+	defer s.Close()
+	assert.Error(t, s.Send(Event{ID: "1\n2", Data: "x"}))
+}
+
+func TestSendRetryOnly(t *testing.T) {
+	s, out := newTestStream(t, "GET /events HTTP/1.1\r\n\r\n", Options{Heartbeat: -1})
+	require.NoError(t, s.Send(Event{Retry: 5 * time.Second}))
+	require.NoError(t, s.Close())
+
+	_, body, found := strings.Cut(out.String(), "\r\n\r\n")
+	require.True(t, found)
+	event := "retry: 5000\n\n"
+	assert.Equal(t, fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(event), event), body)
+}
+
+func TestHeartbeat(t *testing.T) {
+	s, out := newTestStream(t, "GET /events HTTP/1.1\r\n\r\n", Options{Heartbeat: 10 * time.Millisecond})
+	assert.Eventually(t, func() bool {
+		return strings.Contains(out.String(), ": heartbeat\n\n")
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, s.Close())
+}
+
+// A handler that returns without closing the stream leaves it to Finish,
+// which must not race with the heartbeat or be followed by one.
+func TestFinishStopsHeartbeat(t *testing.T) {
+	s, w, out := newTestStreamWriter(t, "GET /events HTTP/1.1\r\n\r\n", Options{Heartbeat: time.Millisecond})
+	assert.Eventually(t, func() bool {
+		return strings.Contains(out.String(), ": heartbeat\n\n")
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, w.Finish())
+	assert.ErrorIs(t, s.Comment("late"), ErrClosed)
+	finished := out.String()
+	assert.True(t, strings.HasSuffix(finished, "\r\n0\r\n\r\n"), finished)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, finished, out.String(), "nothing is written after the last chunk")
+}
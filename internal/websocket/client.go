@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"net"
+	"strings"
+)
+
+// Dial connects to a WebSocket endpoint at address (host:port) and performs
+// the client side of the opening handshake for path.
+func Dial(address, path string) (*Conn, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := NewClient(conn, address, path)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// NewClient performs the client handshake over an established connection
+// and returns the client side of the WebSocket.
+func NewClient(conn net.Conn, host, path string) (*Conn, error) {
+	var rawKey [16]byte
+	if _, err := rand.Read(rawKey[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(rawKey[:])
+
+	handshake := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: " + supportedVersion + "\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	statusLine, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("websocket: reading handshake response: %w", err)
+	}
+	if !strings.HasPrefix(statusLine, "HTTP/1.1 101 ") {
+		return nil, fmt.Errorf("websocket: handshake failed: %s", strings.TrimSpace(statusLine))
+	}
+
+	h := headers.NewHeaders()
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("websocket: reading handshake response: %w", err)
+		}
+		_, done, err := h.Parse([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("websocket: handshake response: %w", err)
+		}
+		if done {
+			break
+		}
+	}
+
+	if !headerHasToken(h.Get("Upgrade"), "websocket") || !headerHasToken(h.Get("Connection"), "upgrade") {
+		return nil, fmt.Errorf("websocket: handshake response is missing upgrade headers")
+	}
+	if h.Get("Sec-WebSocket-Accept") != computeAccept(key) {
+		return nil, fmt.Errorf("websocket: handshake response has wrong Sec-WebSocket-Accept")
+	}
+
+	return newConn(conn, br, true), nil
+}
@@ -0,0 +1,281 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = MessageType(opText)
+	BinaryMessage MessageType = MessageType(opBinary)
+)
+
+// Close status codes from RFC 6455 (7.4.1).
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+// DefaultMaxMessageSize bounds a reassembled message when none is set.
+const DefaultMaxMessageSize = 16 << 20
+
+// closeTimeout bounds how long writing a close frame may block.
+const closeTimeout = 5 * time.Second
+
+// CloseError is returned by ReadMessage once a close frame has been
+// received, or when the connection is failed locally with a close code.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+var errCloseSent = errors.New("websocket: close frame already sent")
+
+func errProtocol(text string) error {
+	return &CloseError{Code: CloseProtocolError, Text: text}
+}
+
+// Conn is a WebSocket connection, either the server side returned by
+// Upgrade or the client side returned by Dial.
+type Conn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isClient bool
+
+	// MaxMessageSize limits the size of a message assembled by
+	// ReadMessage, across all of its fragments.
+	MaxMessageSize int64
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, isClient bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{
+		conn:           conn,
+		br:             br,
+		isClient:       isClient,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+// NetConn returns the underlying network connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// ReadMessage returns the next complete data message, reassembling
+// fragmented messages. Pings are answered automatically and pongs are
+// discarded. When the peer sends a close frame, the close is echoed and a
+// *CloseError carrying the peer's status is returned. Protocol violations
+// fail the connection with the matching close code.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		msgType MessageType
+		message []byte
+		inMsg   bool
+	)
+
+	for {
+		f, err := readFrame(c.br, c.MaxMessageSize)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		if f.rsv != 0 {
+			return 0, nil, c.fail(errProtocol("reserved bits set without a negotiated extension"))
+		}
+		if f.masked == c.isClient {
+			if c.isClient {
+				return 0, nil, c.fail(errProtocol("server frames must not be masked"))
+			}
+			return 0, nil, c.fail(errProtocol("client frames must be masked"))
+		}
+
+		switch f.opcode {
+		case opPing:
+			// Once our close frame is out we may not send anything else,
+			// so pings arriving during the closing handshake go unanswered.
+			if err := c.writeControl(opPong, f.payload); err != nil && !errors.Is(err, errCloseSent) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if inMsg {
+				return 0, nil, c.fail(errProtocol("new message started before previous one finished"))
+			}
+			inMsg = true
+			msgType = MessageType(f.opcode)
+			message = f.payload
+		case opContinuation:
+			if !inMsg {
+				return 0, nil, c.fail(errProtocol("continuation frame without a message"))
+			}
+			if int64(len(message)+len(f.payload)) > c.MaxMessageSize {
+				return 0, nil, c.fail(&CloseError{Code: CloseMessageTooBig, Text: "message exceeds limit"})
+			}
+			message = append(message, f.payload...)
+		default:
+			return 0, nil, c.fail(errProtocol(fmt.Sprintf("unknown opcode %d", f.opcode)))
+		}
+
+		if f.fin {
+			if msgType == TextMessage && !utf8.Valid(message) {
+				return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayload, Text: "text message is not valid UTF-8"})
+			}
+			return msgType, message, nil
+		}
+	}
+}
+
+// handleClose answers a close frame from the peer and reports it.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		closeErr = &CloseError{Code: CloseProtocolError, Text: "malformed close payload"}
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !utf8.Valid(payload[2:]) {
+			closeErr = &CloseError{Code: CloseInvalidPayload, Text: "close reason is not valid UTF-8"}
+		}
+	}
+
+	replyCode := closeErr.Code
+	if replyCode == CloseNoStatusReceived {
+		replyCode = CloseNormalClosure
+	}
+	c.WriteClose(replyCode, "")
+	return closeErr
+}
+
+// fail sends a close frame for protocol-level errors before returning err.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		c.WriteClose(closeErr.Code, closeErr.Text)
+	}
+	return err
+}
+
+// WriteMessage sends data as a single, unfragmented message.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	return c.writeFrame(&frame{fin: true, opcode: opcode(messageType), payload: data})
+}
+
+// WriteFragmented sends data as one message split into frames carrying at
+// most fragmentSize bytes each.
+func (c *Conn) WriteFragmented(messageType MessageType, data []byte, fragmentSize int) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	if fragmentSize <= 0 {
+		return fmt.Errorf("websocket: invalid fragment size %d", fragmentSize)
+	}
+
+	op := opcode(messageType)
+	for {
+		n := min(fragmentSize, len(data))
+		last := n == len(data)
+		if err := c.writeFrame(&frame{fin: last, opcode: op, payload: data[:n]}); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		data = data[n:]
+		op = opContinuation
+	}
+}
+
+// Ping sends a ping frame; the peer's pong is consumed by ReadMessage.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// WriteClose starts (or completes) the closing handshake by sending a close
+// frame with code and reason. Only the first call sends anything. The caller
+// should keep calling ReadMessage until it returns a *CloseError, then Close.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	defer c.conn.SetWriteDeadline(time.Time{})
+	return c.writeFrameLocked(&frame{fin: true, opcode: opClose, payload: payload})
+}
+
+// Close closes the underlying network connection without a closing
+// handshake; use WriteClose first for a clean shutdown.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) writeControl(op opcode, payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return fmt.Errorf("websocket: control frame payload too long")
+	}
+	return c.writeFrame(&frame{fin: true, opcode: op, payload: payload})
+}
+
+func (c *Conn) writeFrame(f *frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return errCloseSent
+	}
+	return c.writeFrameLocked(f)
+}
+
+// writeFrameLocked masks frames sent by a client, as every client-to-server
+// frame must be (RFC 6455 5.1).
+func (c *Conn) writeFrameLocked(f *frame) error {
+	if c.isClient {
+		f.masked = true
+		if _, err := rand.Read(f.maskKey[:]); err != nil {
+			return err
+		}
+	}
+	return writeFrame(c.conn, f)
+}
@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type opcode byte
+
+// Opcodes defined by RFC 6455 (5.2).
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xA
+)
+
+func (op opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+// maxControlPayload is the largest payload a control frame may carry.
+const maxControlPayload = 125
+
+type frame struct {
+	fin     bool
+	rsv     byte
+	opcode  opcode
+	masked  bool
+	maskKey [4]byte
+	payload []byte
+}
+
+// readFrame decodes one frame from r (RFC 6455 5.2). Payloads longer than
+// maxPayload are refused before they are read. The payload is unmasked in
+// place.
+func readFrame(r io.Reader, maxPayload int64) (*frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		fin:    head[0]&0x80 != 0,
+		rsv:    head[0] & 0x70,
+		opcode: opcode(head[0] & 0x0f),
+		masked: head[1]&0x80 != 0,
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length&(1<<63) != 0 {
+			return nil, errProtocol("payload length has most significant bit set")
+		}
+	}
+
+	if f.opcode.isControl() {
+		if !f.fin {
+			return nil, errProtocol("fragmented control frame")
+		}
+		if length > maxControlPayload {
+			return nil, errProtocol("control frame payload too long")
+		}
+	}
+	if length > uint64(maxPayload) {
+		return nil, &CloseError{Code: CloseMessageTooBig, Text: fmt.Sprintf("frame of %d bytes exceeds limit", length)}
+	}
+
+	if f.masked {
+		if _, err := io.ReadFull(r, f.maskKey[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+	if f.masked {
+		maskBytes(f.maskKey, f.payload)
+	}
+	return f, nil
+}
+
+// writeFrame encodes f to w. When f.masked is set the payload is masked with
+// f.maskKey on a copy, leaving the caller's slice untouched.
+func writeFrame(w io.Writer, f *frame) error {
+	buf := make([]byte, 0, 14+len(f.payload))
+
+	b0 := byte(f.opcode) | f.rsv
+	if f.fin {
+		b0 |= 0x80
+	}
+	buf = append(buf, b0)
+
+	var maskBit byte
+	if f.masked {
+		maskBit = 0x80
+	}
+	length := len(f.payload)
+	switch {
+	case length <= 125:
+		buf = append(buf, maskBit|byte(length))
+	case length <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(length))
+	}
+
+	if f.masked {
+		buf = append(buf, f.maskKey[:]...)
+		start := len(buf)
+		buf = append(buf, f.payload...)
+		maskBytes(f.maskKey, buf[start:])
+	} else {
+		buf = append(buf, f.payload...)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// maskBytes applies (or removes) the client-to-server masking transform
+// (RFC 6455 5.3).
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+)
+
+// acceptGUID is the fixed GUID appended to the client key (RFC 6455 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// supportedVersion is the only Sec-WebSocket-Version we speak.
+const supportedVersion = "13"
+
+// computeAccept derives Sec-WebSocket-Accept from Sec-WebSocket-Key.
+func computeAccept(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Upgrade validates an opening handshake (RFC 6455 4.2.1), replies with 101
+// Switching Protocols and takes over the connection from the server. On a
+// bad handshake it writes an error response itself and returns an error; the
+// handler should then simply return.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if err := accept(w, req); err != nil {
+		return nil, err
+	}
+	conn, rw, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// accept validates an opening handshake (RFC 6455 4.2.1) and replies with
+// 101 Switching Protocols, after which the connection speaks WebSocket. On a
+// bad handshake it writes an error response instead and returns an error.
+func accept(w *response.Writer, req *request.Request) error {
+	if err := checkHandshake(req); err != nil {
+		h := headers.NewHeaders()
+		statusCode := response.StatusBadRequest
+		if req.Headers.Get("Sec-WebSocket-Version") != supportedVersion {
+			statusCode = response.StatusUpgradeRequired
+			h.Set("Sec-WebSocket-Version", supportedVersion)
+		}
+		w.WriteError(statusCode, h)
+		return err
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", computeAccept(req.Headers.Get("Sec-WebSocket-Key")))
+
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return err
+	}
+	return w.WriteHeaders(h)
+}
+
+func checkHandshake(req *request.Request) error {
+	if req.RequestLine.Method != "GET" {
+		return fmt.Errorf("websocket: handshake must use GET, got %s", req.RequestLine.Method)
+	}
+	if !headerHasToken(req.Headers.Get("Connection"), "upgrade") {
+		return fmt.Errorf("websocket: Connection header does not contain 'upgrade'")
+	}
+	if !headerHasToken(req.Headers.Get("Upgrade"), "websocket") {
+		return fmt.Errorf("websocket: Upgrade header does not contain 'websocket'")
+	}
+	if v := req.Headers.Get("Sec-WebSocket-Version"); v != supportedVersion {
+		return fmt.Errorf("websocket: unsupported version %q", v)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(req.Headers.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return fmt.Errorf("websocket: invalid Sec-WebSocket-Key")
+	}
+	return nil
+}
+
+// headerHasToken reports whether a comma-separated header value contains
+// token, compared case-insensitively.
+func headerHasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
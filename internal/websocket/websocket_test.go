@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeAccept(t *testing.T) {
+	// Example from RFC 6455 (1.3).
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", computeAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+// startEchoServer emulates server.handle on one end of a pipe: it parses
+// the handshake request and runs an echo handler that records how the
+// connection was closed.
+func startEchoServer(t *testing.T) (net.Conn, <-chan error) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		req, err := request.RequestFromReader(serverConn)
+		if err != nil {
+			done <- err
+			return
+		}
+		w := response.NewWriter(serverConn)
+		w.SetBuffered(req.Buffered())
+		ws, err := Upgrade(w, req)
+		if err != nil {
+			serverConn.Close()
+			done <- err
+			return
+		}
+		defer ws.Close()
+		for {
+			messageType, data, err := ws.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			if err := ws.WriteMessage(messageType, data); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	t.Cleanup(func() { clientConn.Close() })
+	return clientConn, done
+}
+
+func TestEcho(t *testing.T) {
+	conn, done := startEchoServer(t)
+	client, err := NewClient(conn, "example.com", "/ws")
+	require.NoError(t, err)
+
+	t.Run("Text", func(t *testing.T) {
+		require.NoError(t, client.WriteMessage(TextMessage, []byte("hello")))
+		messageType, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TextMessage, messageType)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	t.Run("Binary with extended length", func(t *testing.T) {
+		payload := make([]byte, 70000)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+		require.NoError(t, client.WriteMessage(BinaryMessage, payload))
+		messageType, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, BinaryMessage, messageType)
+		assert.Equal(t, payload, data)
+	})
+
+	t.Run("Fragmented message", func(t *testing.T) {
+		require.NoError(t, client.WriteFragmented(TextMessage, []byte("one two three"), 4))
+		_, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "one two three", string(data))
+	})
+
+	t.Run("Ping is answered with pong", func(t *testing.T) {
+		require.NoError(t, client.Ping([]byte("are you there")))
+		f, err := readFrame(client.br, maxControlPayload)
+		require.NoError(t, err)
+		assert.Equal(t, opPong, f.opcode)
+		assert.False(t, f.masked)
+		assert.Equal(t, "are you there", string(f.payload))
+	})
+
+	t.Run("Close handshake", func(t *testing.T) {
+		require.NoError(t, client.WriteClose(CloseNormalClosure, "bye"))
+		_, _, err := client.ReadMessage()
+		var closeErr *CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, CloseNormalClosure, closeErr.Code)
+
+		serverErr := <-done
+		require.ErrorAs(t, serverErr, &closeErr)
+		assert.Equal(t, CloseNormalClosure, closeErr.Code)
+		assert.Equal(t, "bye", closeErr.Text)
+	})
+}
+
+func TestFrameSentWithHandshake(t *testing.T) {
+	conn, _ := startEchoServer(t)
+
+	// A client that does not wait for 101 before sending its first frame;
+	// the frame is read along with the request and must survive the hijack.
+	var raw bytes.Buffer
+	raw.WriteString("GET /ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	require.NoError(t, writeFrame(&raw, &frame{fin: true, opcode: opText, masked: true, maskKey: [4]byte{1, 2, 3, 4}, payload: []byte("early")}))
+	go conn.Write(raw.Bytes())
+
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	f, err := readFrame(br, DefaultMaxMessageSize)
+	require.NoError(t, err)
+	assert.Equal(t, "early", string(f.payload))
+}
+
+func TestUnmaskedClientFrameIsRejected(t *testing.T) {
+	conn, done := startEchoServer(t)
+	client, err := NewClient(conn, "example.com", "/ws")
+	require.NoError(t, err)
+
+	go writeFrame(conn, &frame{fin: true, opcode: opText, payload: []byte("naked")})
+
+	f, err := readFrame(client.br, maxControlPayload)
+	require.NoError(t, err)
+	assert.Equal(t, opClose, f.opcode)
+	assert.Equal(t, []byte{0x03, 0xea}, f.payload[:2]) // 1002
+
+	var closeErr *CloseError
+	require.ErrorAs(t, <-done, &closeErr)
+	assert.Equal(t, CloseProtocolError, closeErr.Code)
+}
+
+func TestBadHandshake(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    string
+		statusLine string
+	}{
+		{
+			name:       "Missing upgrade headers",
+			request:    "GET /ws HTTP/1.1\r\nHost: x\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n",
+			statusLine: "HTTP/1.1 400 Bad Request",
+		},
+		{
+			name:       "Wrong version",
+			request:    "GET /ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 8\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n",
+			statusLine: "HTTP/1.1 426 Upgrade Required",
+		},
+		{
+			name:       "Short key",
+			request:    "GET /ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: c2hvcnQ=\r\n\r\n",
+			statusLine: "HTTP/1.1 400 Bad Request",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := request.RequestFromReader(strings.NewReader(tt.request))
+			require.NoError(t, err)
+
+			var buf strings.Builder
+			_, err = Upgrade(response.NewWriter(&buf), req)
+			require.Error(t, err)
+
+			statusLine, _ := bufio.NewReader(strings.NewReader(buf.String())).ReadString('\n')
+			assert.Equal(t, tt.statusLine, strings.TrimSpace(statusLine))
+		})
+	}
+}